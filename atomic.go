@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes b to path through a temporary file in the same
+// directory which is renamed into place once fully written, so readers
+// never see a partially written file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(b)
+
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		removeTemp(tmp.Name())
+	}
+
+	return err
+}
+
+// keepTemp is set by the global --keep-temp flag to leave temporary files
+// behind for debugging.
+var keepTemp bool
+
+// removeTemp removes the temporary file or directory at path. With
+// --keep-temp it is kept instead and its path printed to stderr.
+func removeTemp(path string) {
+	if !keepTemp {
+		os.RemoveAll(path)
+		return
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		fmt.Fprintf(os.Stderr, "kept temporary %s\n", path)
+	}
+}
@@ -5,12 +5,23 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type cmderr struct {
@@ -23,9 +34,24 @@ func (e *cmderr) Error() string {
 }
 
 func main() {
+	flag.StringVar(&colorMode, "color", "auto", "color output: auto, always or never")
+	flag.BoolVar(&keepTemp, "keep-temp", false, "keep temporary files and print their paths to stderr")
+	maxConcurrency := flag.Int("max-concurrency-global", runtime.GOMAXPROCS(0), "run at most `N` workers at a time across all concurrent operations")
 	flag.Parse()
 	args := flag.Args()
 
+	if *maxConcurrency < 1 {
+		fmt.Fprintln(os.Stderr, "max-concurrency-global must be at least 1")
+		os.Exit(2)
+	}
+
+	setMaxConcurrency(*maxConcurrency)
+
+	if colorMode != "auto" && colorMode != "always" && colorMode != "never" {
+		fmt.Fprintf(os.Stderr, "%s is not a valid color mode, expected auto, always or never\n", colorMode)
+		os.Exit(2)
+	}
+
 	if len(args) < 1 {
 		fmt.Println("usage: bin <command> [<args>]")
 		fmt.Println()
@@ -33,6 +59,14 @@ func main() {
 		fmt.Println("  package   generates a zip containing binaries and checksums")
 		fmt.Println("  checksum  generates a SHA256 checksum for a binary")
 		fmt.Println("  validate  checks if a binary has a valid SHA256 checksum")
+		fmt.Println("  extract   unpacks the binaries of a package into a directory")
+		fmt.Println("  stat      shows the metadata of a single file in a package")
+		fmt.Println("  self-update  replaces bin with the one from a remote package")
+		fmt.Println("  explain   decodes the name of a package entry")
+		fmt.Println("  fetch     downloads and validates a remote package without installing it")
+		fmt.Println("  uninstall removes the files recorded by install --install-manifest")
+		fmt.Println("  reassemble  joins the parts of a split package")
+		fmt.Println("  merge     combines several packages into one")
 		os.Exit(0)
 	}
 
@@ -48,252 +82,2682 @@ func main() {
 		err = inspectCommand(args[1:])
 	case "install":
 		err = installCommand(args[1:])
+	case "extract":
+		err = extractCommand(args[1:])
+	case "stat":
+		err = statCommand(args[1:])
+	case "self-update":
+		err = selfUpdateCommand(args[1:])
+	case "explain":
+		err = explainCommand(args[1:])
+	case "fetch":
+		err = fetchCommand(args[1:])
+	case "uninstall":
+		err = uninstallCommand(args[1:])
+	case "reassemble":
+		err = reassembleCommand(args[1:])
+	case "merge":
+		err = mergeCommand(args[1:])
 	default:
 		err = &cmderr{1, fmt.Sprintf("%s is an unkown command.\n", args[0])}
 	}
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.reason)
+		if err.reason != "" {
+			fmt.Fprintln(os.Stderr, err.reason)
+		}
 		os.Exit(err.code)
 	}
 }
 
+// listFlag collects the values of a flag that may be given multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseFlags parses args with flags and returns the remaining positional
+// arguments. Unlike flags.Parse, flags may also follow positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, *cmderr) {
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, &cmderr{0, ""}
+			}
+			return nil, &cmderr{2, ""}
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func checksum(b []byte) string {
 	hasher := sha256.New()
 	hasher.Write(b)
 	return fmt.Sprintf("sha256:%x", hasher.Sum(nil))
 }
 
-func installCommand(args []string) *cmderr {
-	if len(args) < 1 {
-		return &cmderr{1, "missing path to package as first argument"}
+// algorithms lists the supported checksum algorithms, weakest first.
+var algorithms = []string{"sha256", "sha512"}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("%s is not a supported checksum algorithm", algorithm)
 	}
+}
 
-	var pkg, sum string
+// checksumWith is like checksum but hashes b with the given algorithm.
+func checksumWith(algorithm string, b []byte) (string, error) {
+	hasher, err := newHash(algorithm)
 
-	if !strings.Contains(args[0], ".package") {
-		pkg = fmt.Sprintf("%s.package", args[0])
-		sum = fmt.Sprintf("%s.checksum", args[0])
-	} else {
-		pkg = args[0]
-		sum = fmt.Sprintf("%s.checksum", strings.Replace(args[0], ".package", "", 1))
+	if err != nil {
+		return "", err
 	}
 
-	//os, arch := runtime.GOOS, runtime.GOARCH
+	hasher.Write(b)
+	return fmt.Sprintf("%s:%x", algorithm, hasher.Sum(nil)), nil
+}
 
-	if err := validateCommand([]string{pkg, sum}); err != nil {
-		return err
-	}
+// Bounds of the buffer size accepted by checksumFile.
+const (
+	minHashBuffer = 4 << 10
+	maxHashBuffer = 64 << 20
+)
 
-	file, err := os.Open(pkg)
-	defer file.Close()
+// checksumFile is like checksumWith but streams the file at path through the
+// hash, reading bufSize bytes at a time. A zero bufSize uses the default
+// buffer of io.Copy.
+func checksumFile(algorithm, path string, bufSize int) (string, error) {
+	hasher, err := newHash(algorithm)
 
 	if err != nil {
-		return &cmderr{1, err.Error()}
+		return "", err
 	}
 
-	gr, err := gzip.NewReader(file)
-	defer gr.Close()
+	file, err := os.Open(path)
 
 	if err != nil {
-		return &cmderr{1, err.Error()}
+		return "", err
 	}
+	defer file.Close()
 
-	tr := tar.NewReader(gr)
+	var buf []byte
+	if bufSize > 0 {
+		buf = make([]byte, bufSize)
+	}
 
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return &cmderr{1, err.Error()}
-		}
+	// Hide the WriterTo of *os.File, which would bypass buf.
+	if _, err := io.CopyBuffer(hasher, struct{ io.Reader }{file}, buf); err != nil {
+		return "", err
+	}
 
-		content, err := io.ReadAll(tr)
+	return fmt.Sprintf("%s:%x", algorithm, hasher.Sum(nil)), nil
+}
 
-		if err != nil {
-			return &cmderr{1, err.Error()}
+// strength ranks algorithm among the supported algorithms, -1 if unknown.
+func strength(algorithm string) int {
+	for i, a := range algorithms {
+		if a == algorithm {
+			return i
 		}
+	}
+	return -1
+}
 
-		name := strings.Split(header.Name, ":")[2]
-
-		if err := os.WriteFile(fmt.Sprintf(".bin/%s", name), content, fs.FileMode(header.Mode)); err != nil {
-			return &cmderr{1, err.Error()}
-		}
+func installCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	strip := flags.Int("strip-components", 0, "strip `N` leading path components from entry names")
+	showProgress := flags.Bool("progress", false, "report progress on stderr when it is a terminal")
+	atomic := flags.Bool("atomic", false, "only update .bin once every entry of the package was extracted")
+	printPath := flags.Bool("print-path", false, "print the absolute paths of the installed files")
+	refuseLinks := flags.Bool("refuse-symlinks", false, "fail on symlink and hard link entries")
+	requireExec := flags.Bool("require-executable", false, "fail on entries without any executable bit")
+	preserveXattrs := flags.Bool("preserve-xattrs", false, "restore the extended attributes recorded in the package")
+	followDeps := flags.Bool("follow-package-deps", false, "install the dependencies declared by the package first")
+	depsDir := flags.String("deps-dir", "", "look up dependencies in `dir` instead of next to the package")
+	lockPath := flags.String("lockfile", "", "record the installed package in the lockfile at `path`")
+	frozen := flags.Bool("frozen", false, "only install if the package matches the one pinned in the lockfile")
+	keepIndex := flags.Bool("keep-index", false, "also extract the package index and metadata to .bin/bin")
+	platformDefault := flags.String("platform-default", "any", "install entries without a platform on `any` platform or none")
+	normalizeMode := flags.Bool("normalize-mode", false, "install executables as 0755 and other files as 0644 whatever their packaged mode")
+	progressTo := flags.String("progress-to", "", "also log progress to the file at `path`, even without a terminal")
+	noRequireChecksum := flags.Bool("no-require-checksum-file", false, "install without validating if the package has no checksum file")
+	strictTar := flags.Bool("strict-tar", true, "fail on entries other than regular files and hard links, such as devices")
+	allowSpecial := flags.Bool("allow-special", false, "skip entries rejected by --strict-tar with a warning instead of failing")
+	strictPerms := flags.Bool("strict-permissions", false, "fail on world-writable entries")
+	printDigest := flags.Bool("print-checksum-after-install", false, "print the packaged sha256 checksum of every file once it is written")
+	dereference := flags.Bool("dereference-checksum", false, "print the sha256 checksum of every file as written to disk")
+	installManifest := flags.String("install-manifest", "", "record the files written to the manifest at `path` for uninstall")
+	retryOnLocked := flags.Bool("retry-on-locked", false, "retry writing binaries that are in use, such as running ones on Windows")
+	retries := flags.Int("retries", 5, "with --retry-on-locked, retry up to `N` times with increasing delays")
+	compare := flags.Bool("compare-installed", false, "only write the files that differ from the installed ones and report their status")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
 	}
 
-	return nil
-}
+	if *preserveXattrs {
+		warnXattrs()
+	}
 
-func inspectCommand(args []string) *cmderr {
 	if len(args) < 1 {
 		return &cmderr{1, "missing path to package as first argument"}
 	}
 
-	file, err := os.Open(args[0])
-	defer file.Close()
+	if *frozen && *lockPath == "" {
+		return &cmderr{1, "--frozen requires --lockfile"}
+	}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+	if *platformDefault != "any" && *platformDefault != "none" {
+		return &cmderr{1, fmt.Sprintf("%s is not a valid platform default, expected any or none", *platformDefault)}
 	}
 
-	gr, err := gzip.NewReader(file)
-	defer gr.Close()
+	var pkg, sum string
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+	if !strings.Contains(args[0], ".package") {
+		pkg = fmt.Sprintf("%s.package", args[0])
+		sum, cerr = findChecksum(args[0])
+	} else {
+		pkg = args[0]
+		sum, cerr = findChecksum(packageBase(args[0]))
+	}
+
+	switch {
+	case cerr != nil && !*noRequireChecksum:
+		return cerr
+	case cerr != nil:
+		fmt.Fprintf(os.Stderr, "warning: installing %s unverified: %s\n", pkg, cerr.reason)
+	default:
+		if err := validateCommand([]string{pkg, sum}); err != nil {
+			return err
+		}
 	}
 
-	tr := tar.NewReader(gr)
+	name := filepath.Base(packageBase(pkg))
+	var lock lockfile
+	var locked lockEntry
 
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
+	if *lockPath != "" {
+		file, _, err := openPackageFile(pkg)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
 		}
+
+		b, err := io.ReadAll(file)
+		file.Close()
+
 		if err != nil {
 			return &cmderr{1, err.Error()}
 		}
-		fmt.Println(hdr.Name)
-	}
 
-	return nil
-}
+		version, cerr := readMetadata(pkg, "version")
+		if cerr != nil {
+			return cerr
+		}
 
-func packageCommand(args []string) *cmderr {
-	if len(args) < 1 {
-		return &cmderr{1, "missing path to folder or binary as first argument"}
-	}
+		locked = lockEntry{Digest: checksum(b), Version: strings.TrimSpace(string(version))}
 
-	var buf bytes.Buffer
-	gw := gzip.NewWriter(&buf)
-	tw := tar.NewWriter(gw)
+		lock, err = readLockfile(*lockPath)
 
-	bin, err := os.Open(args[0])
-	defer bin.Close()
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
-	}
+		if *frozen {
+			pinned, ok := lock[name]
 
-	stat, err := os.Stat(args[0])
+			if !ok {
+				return &cmderr{1, fmt.Sprintf("%s is not pinned in %s", name, *lockPath)}
+			}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+			if pinned.Digest != locked.Digest {
+				return &cmderr{1, fmt.Sprintf("%s does not match the digest pinned in %s", pkg, *lockPath)}
+			}
+		}
 	}
 
-	b, err := io.ReadAll(bin)
+	opts := extractOptions{platform: runtime.GOOS + "/" + runtime.GOARCH, skipUntagged: *platformDefault == "none", strip: *strip, progress: *showProgress, atomic: *atomic, refuseLinks: *refuseLinks, requireExec: *requireExec, strictPerms: *strictPerms, normalizeMode: *normalizeMode, printDigest: *printDigest, strictTar: *strictTar && !*allowSpecial, xattrs: *preserveXattrs, keepIndex: *keepIndex}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+	if *compare {
+		opts.compareTo = ".bin"
 	}
 
-	header := &tar.Header{
-		Name: fmt.Sprintf("%s:%s", checksum(b), args[0]),
-		Mode: int64(stat.Mode()),
-		Size: int64(len(b)),
+	if *progressTo != "" {
+		log, err := os.Create(*progressTo)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+		defer log.Close()
+
+		opts.progressTo = log
 	}
 
-	if err := tw.WriteHeader(header); err != nil {
-		return &cmderr{1, err.Error()}
+	if *retryOnLocked {
+		opts.retries = *retries
 	}
 
-	if _, err := tw.Write(b); err != nil {
-		return &cmderr{1, err.Error()}
+	var paths []string
+
+	if *followDeps {
+		dir := *depsDir
+		if dir == "" {
+			dir = filepath.Dir(pkg)
+		}
+
+		written, err := installDeps(pkg, dir, []string{name}, map[string]bool{}, opts)
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, written...)
 	}
 
-	if err := tw.Close(); err != nil {
-		return &cmderr{1, err.Error()}
+	written, err := extract(pkg, ".bin", opts)
+	if err != nil {
+		return err
 	}
 
-	if err := gw.Close(); err != nil {
-		return &cmderr{1, err.Error()}
+	paths = append(paths, written...)
+
+	if *lockPath != "" && !*frozen {
+		lock[name] = locked
+
+		if err := writeLockfile(*lockPath, lock); err != nil {
+			return &cmderr{1, err.Error()}
+		}
 	}
 
-	file, err := os.Create(fmt.Sprintf("%s.package", args[0]))
-	defer file.Close()
+	if *dereference {
+		for _, p := range paths {
+			sum, err := checksumFile("sha256", p, 0)
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+			if err != nil {
+				return &cmderr{1, err.Error()}
+			}
+
+			fmt.Printf("%s %s\n", p, sum)
+		}
 	}
 
-	b = buf.Bytes()
-	if _, err := file.Write(b); err != nil {
-		return &cmderr{1, err.Error()}
+	if *installManifest != "" {
+		if err := writeInstallManifest(*installManifest, paths); err != nil {
+			return &cmderr{1, err.Error()}
+		}
 	}
 
-	file, err = os.Create(fmt.Sprintf("%s.checksum", args[0]))
-	defer file.Close()
+	if *printPath {
+		for _, p := range paths {
+			abs, err := filepath.Abs(p)
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
-	}
+			if err != nil {
+				return &cmderr{1, err.Error()}
+			}
 
-	if _, err := file.WriteString(fmt.Sprintf("%s\n", checksum(b))); err != nil {
-		return &cmderr{1, err.Error()}
+			fmt.Println(abs)
+		}
 	}
 
 	return nil
 }
 
-func checksumCommand(args []string) *cmderr {
-	if len(args) < 1 {
-		return &cmderr{1, "missing path to binary as first argument"}
+// installDeps installs the dependencies declared by the package at pkg before
+// the package itself, depth first. Dependencies are looked up as
+// <name>.package next to a <name>.checksum in dir and must match the checksum
+// they were declared with. chain holds the names of the packages currently
+// being resolved and is used to detect cycles. It returns the paths of the
+// files it wrote.
+func installDeps(pkg, dir string, chain []string, installed map[string]bool, opts extractOptions) ([]string, *cmderr) {
+	b, cerr := readMetadata(pkg, "deps")
+	if cerr != nil {
+		return nil, cerr
 	}
 
-	bin, err := os.Open(args[0])
+	var paths []string
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+	for _, line := range strings.Fields(string(b)) {
+		d, err := parseDep(line)
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		for _, name := range chain {
+			if name == d.name {
+				return nil, &cmderr{1, fmt.Sprintf("dependency cycle: %s -> %s", strings.Join(chain, " -> "), d.name)}
+			}
+		}
+
+		if installed[d.name] {
+			continue
+		}
+
+		dep := filepath.Join(dir, fmt.Sprintf("%s.package", d.name))
+		sum, cerr := findChecksum(filepath.Join(dir, d.name))
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		if err := validateCommand([]string{dep, sum}); err != nil {
+			return nil, err
+		}
+
+		b, err := os.ReadFile(sum)
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		// The checksum file may be in the sha256sum format of a .sha256 file.
+		algorithm, digest, err := parseChecksum(string(b))
+
+		if err != nil {
+			return nil, &cmderr{1, fmt.Sprintf("%s: %s", sum, err)}
+		}
+
+		if algorithm+":"+strings.ToLower(digest) != d.checksum {
+			return nil, &cmderr{1, fmt.Sprintf("%s does not match the checksum it was declared with", dep)}
+		}
+
+		written, cerr := installDeps(dep, dir, append(chain[:len(chain):len(chain)], d.name), installed, opts)
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		paths = append(paths, written...)
+
+		written, cerr = extract(dep, ".bin", opts)
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		paths = append(paths, written...)
+		installed[d.name] = true
 	}
 
-	b, err := io.ReadAll(bin)
+	return paths, nil
+}
+
+// dep is a dependency on another package, written as name@checksum.
+type dep struct {
+	name     string
+	checksum string
+}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+func parseDep(s string) (dep, error) {
+	name, sum, ok := strings.Cut(s, "@")
+
+	if !ok || name == "" || sum == "" {
+		return dep{}, fmt.Errorf("%s is not a valid dependency, expected name@checksum", s)
 	}
 
-	fmt.Println(checksum(b))
-	return nil
+	return dep{name, sum}, nil
 }
 
-func validateCommand(args []string) *cmderr {
+func extractCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	strip := flags.Int("strip-components", 0, "strip `N` leading path components from entry names")
+	showProgress := flags.Bool("progress", false, "report progress on stderr when it is a terminal")
+	refuseLinks := flags.Bool("refuse-symlinks", false, "fail on symlink and hard link entries")
+	platform := flags.String("platform", "", "only extract the files for `os/arch` and those for every platform")
+	preserveXattrs := flags.Bool("preserve-xattrs", false, "restore the extended attributes recorded in the package")
+	keepIndex := flags.Bool("keep-index", false, "also extract the package index and metadata to bin/ in the output directory")
+	strictTar := flags.Bool("strict-tar", true, "fail on entries other than regular files and hard links, such as devices")
+	allowSpecial := flags.Bool("allow-special", false, "skip entries rejected by --strict-tar with a warning instead of failing")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if *preserveXattrs {
+		warnXattrs()
+	}
+
 	if len(args) < 1 {
-		return &cmderr{1, "missing path to binary as first argument"}
+		return &cmderr{1, "missing path to package as first argument"}
 	}
 
 	if len(args) < 2 {
-		return &cmderr{1, "missing path to checksum as second argument"}
+		return &cmderr{1, "missing path to output directory as second argument"}
 	}
 
-	bin, err := os.Open(args[0])
-
-	if err != nil {
-		return &cmderr{1, err.Error()}
+	if *platform != "" {
+		if err := parsePlatform(*platform); err != nil {
+			return &cmderr{1, err.Error()}
+		}
 	}
 
-	ab, err := io.ReadAll(bin)
+	_, err := extract(args[0], args[1], extractOptions{platform: *platform, strip: *strip, progress: *showProgress, refuseLinks: *refuseLinks, strictTar: *strictTar && !*allowSpecial, xattrs: *preserveXattrs, keepIndex: *keepIndex})
+	return err
+}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+type extractOptions struct {
+	// platform selects the platform specific entries to extract, entries
+	// belonging on every platform are extracted too unless skipUntagged is
+	// set. If empty, all entries are extracted.
+	platform     string
+	skipUntagged bool
+	strip        int
+	progress     bool
+	// progressTo, if set, receives a progress line every few seconds.
+	progressTo  io.Writer
+	atomic      bool
+	refuseLinks bool
+	requireExec bool
+	strictPerms bool
+	// normalizeMode writes files with 0755 if any executable bit is set in
+	// their packaged mode and 0644 otherwise.
+	normalizeMode bool
+	// strictTar only allows regular files and the hard links written by
+	// package --dedup, rejecting symlinks, devices and fifos. Without it
+	// such entries are skipped with a warning, they are never extracted.
+	strictTar bool
+	xattrs    bool
+	// keepIndex extracts the index and other metadata entries to bin/ in
+	// the output directory instead of skipping them.
+	keepIndex bool
+	// compareTo is the directory holding the currently installed files. If
+	// set, entries whose installed file already matches their digest are
+	// not written again and the status of every entry is printed.
+	compareTo string
+	// printDigest prints the path and packaged digest of every file once it
+	// was written. Paths are shown below displayDir if set, which is where
+	// files extracted to a staging directory end up.
+	printDigest bool
+	displayDir  string
+	// retries is how often writing a file locked by another process, such
+	// as a running binary on Windows, is retried.
+	retries int
+}
+
+// extract writes every entry of the package at pkg into dir and returns the
+// paths of the files it wrote, along with the installed files opts.compareTo
+// found unchanged. With opts.atomic the entries are extracted into a staging
+// directory first and only moved into dir once all of them were extracted.
+func extract(pkg, dir string, opts extractOptions) ([]string, *cmderr) {
+	if opts.strip < 0 {
+		return nil, &cmderr{1, "strip-components must not be negative"}
 	}
 
-	checksumFile, err := os.Open(args[1])
+	if opts.atomic {
+		staging, err := os.MkdirTemp(filepath.Dir(dir), fmt.Sprintf(".%s-staging-", filepath.Base(dir)))
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
-	}
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+		defer removeTemp(staging)
 
-	bb, err := io.ReadAll(checksumFile)
+		opts.atomic = false
+		opts.displayDir = dir
+		staged, cerr := extract(pkg, staging, opts)
+		if cerr != nil {
+			return nil, cerr
+		}
 
-	if err != nil {
-		return &cmderr{1, err.Error()}
+		// Unchanged files are already in place and were never staged.
+		var unchanged []string
+		staged = slices.DeleteFunc(staged, func(p string) bool {
+			if strings.HasPrefix(p, staging+string(filepath.Separator)) {
+				return false
+			}
+
+			unchanged = append(unchanged, p)
+			return true
+		})
+
+		promoted, cerr := promote(staging, staged, dir)
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		return append(unchanged, promoted...), nil
 	}
 
-	if checksum(ab) != strings.Trim(string(bb), "\n") {
-		return &cmderr{1, "invalid checksum for binary"}
+	pr, cerr := openPackage(pkg, opts.progress, opts.progressTo)
+	if cerr != nil {
+		return nil, cerr
 	}
+	defer pr.Close()
+
+	// written maps entry names to the files they were extracted to, so
+	// entries deduplicated into links can be copied from them. held keeps the
+	// content of entries that are not extracted, such as those for other
+	// platforms, in case a later entry links to them.
+	written := map[string]string{}
+	held := map[string][]byte{}
+	var paths []string
+
+	hold := func(header *tar.Header) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
 
-	return nil
+		b, err := io.ReadAll(pr)
+
+		if err != nil {
+			return err
+		}
+
+		held[header.Name] = b
+		return nil
+	}
+
+	for {
+		header, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if isMetadata(header.Name) {
+			if opts.keepIndex {
+				target, err := keepMetadata(dir, header.Name, pr)
+
+				if err != nil {
+					return nil, &cmderr{1, err.Error()}
+				}
+
+				paths = append(paths, target)
+			}
+
+			continue
+		}
+
+		if opts.refuseLinks && (header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink) {
+			return nil, &cmderr{1, fmt.Sprintf("%s is a link to %s, refusing to extract it", header.Name, header.Linkname)}
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeLink {
+			if opts.strictTar {
+				return nil, &cmderr{1, fmt.Sprintf("%s has unexpected tar entry type %q, refusing to extract it", header.Name, header.Typeflag)}
+			}
+
+			fmt.Fprintf(os.Stderr, "warning: skipping %s, tar entry type %q is not extracted\n", header.Name, header.Typeflag)
+			continue
+		}
+
+		e, err := parseEntry(header.Name)
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if opts.platform != "" && e.platform() != "" && e.platform() != opts.platform || opts.platform != "" && e.platform() == "" && opts.skipUntagged {
+			if err := hold(header); err != nil {
+				return nil, &cmderr{1, err.Error()}
+			}
+			continue
+		}
+
+		name, err := entryPath(e.path, opts.strip)
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if name == "" {
+			if err := hold(header); err != nil {
+				return nil, &cmderr{1, err.Error()}
+			}
+			continue
+		}
+
+		if opts.requireExec && header.Mode&0111 == 0 {
+			return nil, &cmderr{1, fmt.Sprintf("%s is not executable (mode %s)", e.path, fs.FileMode(header.Mode))}
+		}
+
+		if opts.strictPerms && header.Mode&0002 != 0 {
+			return nil, &cmderr{1, fmt.Sprintf("%s is world-writable (mode %s), refusing to extract it", e.path, fs.FileMode(header.Mode))}
+		}
+
+		if opts.compareTo != "" {
+			status, err := compareInstalled(filepath.Join(opts.compareTo, filepath.FromSlash(name)), e.digest)
+
+			if err != nil {
+				return nil, &cmderr{1, err.Error()}
+			}
+
+			fmt.Printf("%-9s %s\n", status, name)
+
+			if status == "unchanged" {
+				installed := filepath.Join(opts.compareTo, filepath.FromSlash(name))
+				written[header.Name] = installed
+				paths = append(paths, installed)
+				continue
+			}
+		}
+
+		var content []byte
+
+		if header.Typeflag == tar.TypeLink {
+			source, ok := written[header.Linkname]
+
+			switch b, isHeld := held[header.Linkname]; {
+			case ok:
+				content, err = os.ReadFile(source)
+			case isHeld:
+				content = b
+			default:
+				return nil, &cmderr{1, fmt.Sprintf("%s links to %s which was not extracted", header.Name, header.Linkname)}
+			}
+		} else {
+			content, err = io.ReadAll(pr)
+		}
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		mode := fs.FileMode(header.Mode).Perm()
+
+		if opts.normalizeMode {
+			mode = 0644
+			if header.Mode&0111 != 0 {
+				mode = 0755
+			}
+		}
+
+		err = retryLocked(opts.retries, func() error {
+			return os.WriteFile(target, content, mode)
+		})
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		// WriteFile only applies the mode to files it creates.
+		if err := os.Chmod(target, mode); err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if opts.xattrs {
+			if err := setXattrs(target, recordXattrs(header.PAXRecords)); err != nil {
+				return nil, &cmderr{1, fmt.Sprintf("%s: %s", target, err)}
+			}
+		}
+
+		if opts.printDigest {
+			shown := dir
+			if opts.displayDir != "" {
+				shown = opts.displayDir
+			}
+
+			fmt.Printf("%s %s\n", filepath.Join(shown, filepath.FromSlash(name)), e.digest)
+		}
+
+		written[header.Name] = target
+		paths = append(paths, target)
+	}
+
+	return paths, nil
+}
+
+// compareInstalled reports whether the installed file at path is unchanged
+// from, should be updated to, or is new compared to the entry with digest.
+func compareInstalled(path, digest string) (string, error) {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	sum, err := checksumFile(algorithm, path, 0)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "new", nil
+	case err != nil:
+		return "", err
+	case sum == digest:
+		return "unchanged", nil
+	default:
+		return "updated", nil
+	}
+}
+
+// keepMetadata writes the content of the metadata entry name read from r to
+// the same path relative to dir.
+func keepMetadata(dir, name string, r io.Reader) (string, error) {
+	rel, err := entryPath(name, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	content, err := io.ReadAll(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	return target, os.WriteFile(target, content, 0644)
+}
+
+// promote moves the staged files from staging to the same relative path in
+// dir and returns their new paths. If a file cannot be moved, the files moved
+// so far are removed again and the files they replaced are restored.
+func promote(staging string, staged []string, dir string) ([]string, *cmderr) {
+	backup := staging + "-backup"
+	defer removeTemp(backup)
+
+	type move struct {
+		target string
+		backup string
+	}
+
+	var moved []move
+
+	rollback := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			os.Remove(moved[i].target)
+			if moved[i].backup != "" {
+				os.Rename(moved[i].backup, moved[i].target)
+			}
+		}
+	}
+
+	var paths []string
+
+	for _, p := range staged {
+		rel, err := filepath.Rel(staging, p)
+
+		if err != nil {
+			rollback()
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		m := move{target: filepath.Join(dir, rel)}
+
+		if err := os.MkdirAll(filepath.Dir(m.target), 0755); err != nil {
+			rollback()
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if _, err := os.Lstat(m.target); err == nil {
+			m.backup = filepath.Join(backup, rel)
+
+			if err := os.MkdirAll(filepath.Dir(m.backup), 0755); err != nil {
+				rollback()
+				return nil, &cmderr{1, err.Error()}
+			}
+
+			if err := os.Rename(m.target, m.backup); err != nil {
+				rollback()
+				return nil, &cmderr{1, err.Error()}
+			}
+		}
+
+		if err := os.Rename(p, m.target); err != nil {
+			if m.backup != "" {
+				os.Rename(m.backup, m.target)
+			}
+			rollback()
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		moved = append(moved, m)
+		paths = append(paths, m.target)
+	}
+
+	return paths, nil
+}
+
+// entry is a packaged file as described by its tar header name, which has
+// the form <checksum>:<path>, or <checksum>:<os>:<arch>:<path> for files
+// that only belong on one platform.
+type entry struct {
+	digest string
+	os     string
+	arch   string
+	path   string
+}
+
+func parseEntry(name string) (entry, error) {
+	parts := strings.Split(name, ":")
+
+	switch {
+	case len(parts) == 3 && parts[2] != "":
+		return entry{digest: parts[0] + ":" + parts[1], path: parts[2]}, nil
+	case len(parts) == 5 && parts[2] != "" && parts[3] != "" && parts[4] != "":
+		return entry{digest: parts[0] + ":" + parts[1], os: parts[2], arch: parts[3], path: parts[4]}, nil
+	}
+
+	return entry{}, fmt.Errorf("%s is not a valid entry name", name)
+}
+
+// platform returns the os/arch pair the entry belongs on, or an empty string
+// if it belongs on every platform.
+func (e entry) platform() string {
+	if e.os == "" {
+		return ""
+	}
+	return e.os + "/" + e.arch
+}
+
+// entryName returns the tar header name for a file with the given checksum
+// and path, tagged with platform unless it is empty.
+func entryName(sum, platform, path string) string {
+	if platform == "" {
+		return fmt.Sprintf("%s:%s", sum, path)
+	}
+	return fmt.Sprintf("%s:%s:%s", sum, strings.Replace(platform, "/", ":", 1), path)
+}
+
+// parsePlatform checks that s is an os/arch pair such as linux/amd64.
+func parsePlatform(s string) error {
+	goos, goarch, ok := strings.Cut(s, "/")
+
+	if !ok || goos == "" || goarch == "" || strings.ContainsAny(goarch, "/:") || strings.Contains(goos, ":") {
+		return fmt.Errorf("%s is not a valid platform, expected os/arch", s)
+	}
+
+	return nil
+}
+
+// entryPath returns the relative path an entry should be written to after
+// dropping its first strip components, like tar --strip-components. An empty
+// path means the entry has no components left and should be skipped. Paths
+// escaping the destination are rejected.
+func entryPath(name string, strip int) (string, error) {
+	var segments []string
+	for _, s := range strings.Split(path.Clean("/"+name), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	// Backslashes separate paths on Windows, where ..\..\evil would escape
+	// the destination just like ../../evil.
+	if strings.Contains(name, "\\") {
+		return "", fmt.Errorf("%s contains a backslash, which is not allowed in entry paths", name)
+	}
+
+	for _, s := range strings.Split(name, "/") {
+		if s == ".." {
+			return "", fmt.Errorf("%s escapes the destination directory", name)
+		}
+	}
+
+	if len(segments) <= strip {
+		return "", nil
+	}
+
+	rel := strings.Join(segments[strip:], "/")
+
+	// IsLocal also catches volume names and reserved names such as NUL on
+	// Windows.
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("%s escapes the destination directory", name)
+	}
+
+	return rel, nil
+}
+
+// metadataPrefix marks tar entries that describe the package itself rather
+// than a file to install. Entry names of packaged files always start with
+// their checksum, so the two cannot collide.
+const metadataPrefix = "bin/"
+
+func isMetadata(name string) bool {
+	return strings.HasPrefix(name, metadataPrefix)
+}
+
+// tarFormats are the tar header formats package --tar-format accepts.
+var tarFormats = map[string]tar.Format{
+	"pax":   tar.FormatPAX,
+	"gnu":   tar.FormatGNU,
+	"ustar": tar.FormatUSTAR,
+}
+
+func writeMetadata(tw *tar.Writer, format tar.Format, key string, b []byte) error {
+	header := &tar.Header{
+		Name:   metadataPrefix + key,
+		Mode:   0644,
+		Size:   int64(len(b)),
+		Format: format,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(b)
+	return err
+}
+
+// readMetadata returns the content of the metadata entry key of the package
+// at pkg, or nil if the package has no such entry.
+func readMetadata(pkg, key string) ([]byte, *cmderr) {
+	pr, cerr := openPackage(pkg, false, nil)
+	if cerr != nil {
+		return nil, cerr
+	}
+	defer pr.Close()
+
+	for {
+		header, err := pr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		if header.Name != metadataPrefix+key {
+			continue
+		}
+
+		b, err := io.ReadAll(pr)
+
+		if err != nil {
+			return nil, &cmderr{1, err.Error()}
+		}
+
+		return b, nil
+	}
+}
+
+// packageReader reads the tar entries of a package.
+type packageReader struct {
+	*tar.Reader
+	file io.Closer
+	gr   *gzip.Reader
+}
+
+// openPackage opens the package at pkg for reading. If showProgress is set,
+// progress reading the package is reported on stderr, and if progressLog is
+// not nil it is logged there.
+func openPackage(pkg string, showProgress bool, progressLog io.Writer) (*packageReader, *cmderr) {
+	file, size, err := openPackageFile(pkg)
+
+	if err != nil {
+		return nil, &cmderr{1, err.Error()}
+	}
+
+	var r io.Reader = file
+
+	if showProgress {
+		r = newProgress(r, size)
+	}
+
+	if progressLog != nil {
+		r = newProgressLog(r, size, progressLog)
+	}
+
+	gr, err := gzip.NewReader(r)
+
+	if err != nil {
+		file.Close()
+		return nil, &cmderr{1, err.Error()}
+	}
+
+	return &packageReader{tar.NewReader(gr), file, gr}, nil
+}
+
+func (r *packageReader) Close() error {
+	r.gr.Close()
+	return r.file.Close()
+}
+
+func inspectCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the package contents as JSON")
+	canonical := flags.Bool("canonical-json", false, "print the package contents as JSON compact on a single line, for hashing, implies --json")
+	jsonLines := flags.Bool("json-lines", false, "print every entry as a JSON object on its own line while reading the package")
+	listOnly := flags.Bool("list-only", false, "list the entry names from the package index when it has one")
+	sizes := flags.Bool("sizes", false, "list the entries by size, largest first")
+	top := flags.Int("top", 0, "only list the `N` largest entries with --sizes")
+	verifyOrder := flags.Bool("verify-entry-order", false, "fail unless the entries are in the order written by package --sort name")
+	shallow := flags.Int("shallow", 0, "stop reading the package after the first `N` entries")
+	dumpRaw := flags.Bool("dump-header-raw", false, "print every field of the tar header of each entry")
+	shaShort := flags.Int("sha-short", 0, "shorten the listed digests to `N` hex characters, JSON output keeps them whole")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if *shaShort < 0 {
+		return &cmderr{1, "sha-short must not be negative"}
+	}
+
+	if *canonical && !*jsonLines {
+		*asJSON = true
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing path to package as first argument"}
+	}
+
+	pr, cerr := openPackage(args[0], false, nil)
+	if cerr != nil {
+		return cerr
+	}
+	defer pr.Close()
+
+	if *listOnly {
+		return listEntries(pr, *shaShort)
+	}
+
+	if *verifyOrder {
+		return verifyEntryOrder(pr)
+	}
+
+	out := inspectOutput{Entries: []inspectEntry{}}
+
+	seen := 0
+
+	for first := true; *shallow <= 0 || seen < *shallow; first = false {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if !isMetadata(hdr.Name) {
+			seen++
+		}
+
+		if *dumpRaw {
+			if !first {
+				fmt.Println()
+			}
+
+			dumpHeader(hdr)
+			continue
+		}
+
+		if hdr.Name == metadataPrefix+"comment" {
+			b, err := io.ReadAll(pr)
+
+			if err != nil {
+				return &cmderr{1, err.Error()}
+			}
+
+			out.Comment = strings.TrimSpace(string(b))
+
+			if !*asJSON && !*jsonLines {
+				fmt.Printf("%s %s\n", bold("comment:"), out.Comment)
+			}
+
+			continue
+		}
+
+		if !*asJSON && !*jsonLines && !*sizes {
+			fmt.Println(shortName(hdr.Name, *shaShort))
+			continue
+		}
+
+		if isMetadata(hdr.Name) {
+			continue
+		}
+
+		e, err := parseEntry(hdr.Name)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		ie := inspectEntry{
+			Path:     e.path,
+			Digest:   e.digest,
+			Platform: e.platform(),
+			Size:     hdr.Size,
+			Mode:     fmt.Sprintf("%04o", hdr.Mode&07777),
+			Link:     hdr.Linkname,
+		}
+
+		if *jsonLines {
+			b, err := canonicalJSON(ie, false)
+
+			if err != nil {
+				return &cmderr{1, err.Error()}
+			}
+
+			fmt.Println(string(b))
+			continue
+		}
+
+		out.Entries = append(out.Entries, ie)
+	}
+
+	if *asJSON {
+		b, err := canonicalJSON(out, !*canonical)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if *sizes {
+		printSizes(out.Entries, *top)
+	}
+
+	return nil
+}
+
+// printSizes prints a table of entries ranked by size, largest first, with
+// the share of the total size taken up by each entry and the ones before it.
+// If top is positive only the top largest entries are listed.
+func printSizes(entries []inspectEntry, top int) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Size > entries[j].Size
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	if top > 0 && top < len(entries) {
+		entries = entries[:top]
+	}
+
+	fmt.Printf("%10s %7s  %s\n", "SIZE", "CUM%", "PATH")
+
+	var cumulative int64
+	for _, e := range entries {
+		cumulative += e.Size
+
+		percent := 100.0
+		if total > 0 {
+			percent = float64(cumulative) * 100 / float64(total)
+		}
+
+		fmt.Printf("%10s %6.1f%%  %s\n", formatBytes(e.Size), percent, e.Path)
+	}
+}
+
+// listEntries prints the names of the file entries of a package. If the
+// package starts with an index only the index is read, otherwise all headers.
+func listEntries(pr *packageReader, shaShort int) *cmderr {
+	for first := true; ; first = false {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if first && hdr.Name == metadataPrefix+"index" {
+			var index packageIndex
+
+			if err := json.NewDecoder(pr).Decode(&index); err != nil {
+				return &cmderr{1, fmt.Sprintf("malformed package index: %s", err)}
+			}
+
+			for _, e := range index.Entries {
+				fmt.Println(shortName(e.Name, shaShort))
+			}
+
+			return nil
+		}
+
+		if !isMetadata(hdr.Name) {
+			fmt.Println(shortName(hdr.Name, shaShort))
+		}
+	}
+}
+
+// dumpHeader prints every field of hdr, for debugging packages written by
+// other tar implementations.
+func dumpHeader(hdr *tar.Header) {
+	field := func(label string, value any) {
+		fmt.Printf("%s %v\n", bold(fmt.Sprintf("%-11s", label+":")), value)
+	}
+
+	field("name", hdr.Name)
+	field("typeflag", fmt.Sprintf("%q", hdr.Typeflag))
+	field("linkname", hdr.Linkname)
+	field("size", hdr.Size)
+	field("mode", fmt.Sprintf("%04o", hdr.Mode))
+	field("uid", hdr.Uid)
+	field("gid", hdr.Gid)
+	field("uname", hdr.Uname)
+	field("gname", hdr.Gname)
+	field("modtime", hdr.ModTime.UTC().Format(time.RFC3339))
+	field("accesstime", hdr.AccessTime.UTC().Format(time.RFC3339))
+	field("changetime", hdr.ChangeTime.UTC().Format(time.RFC3339))
+	field("devmajor", hdr.Devmajor)
+	field("devminor", hdr.Devminor)
+	field("format", hdr.Format)
+
+	keys := make([]string, 0, len(hdr.PAXRecords))
+	for k := range hdr.PAXRecords {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		field("pax", fmt.Sprintf("%s=%q", k, hdr.PAXRecords[k]))
+	}
+}
+
+// verifyEntryOrder checks that the file entries of the package are sorted
+// by path, as package --sort name writes them.
+func verifyEntryOrder(pr *packageReader) *cmderr {
+	var previous string
+
+	for {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if isMetadata(hdr.Name) {
+			continue
+		}
+
+		e, err := parseEntry(hdr.Name)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if e.path < previous {
+			return &cmderr{1, fmt.Sprintf("entries are not sorted by name, %s comes after %s", e.path, previous)}
+		}
+
+		previous = e.path
+	}
+}
+
+// shortName returns the entry name with its digest shortened to n hex
+// characters. Metadata names and names with shorter digests are returned
+// as is, as are all names if n is zero.
+func shortName(name string, n int) string {
+	e, err := parseEntry(name)
+
+	if n == 0 || isMetadata(name) || err != nil {
+		return name
+	}
+
+	algorithm, hex, _ := strings.Cut(e.digest, ":")
+
+	if len(hex) <= n {
+		return name
+	}
+
+	return entryName(algorithm+":"+hex[:n], e.platform(), e.path)
+}
+
+// inspectOutput is the JSON form of inspect.
+type inspectOutput struct {
+	Comment string         `json:"comment,omitempty"`
+	Entries []inspectEntry `json:"entries"`
+}
+
+type inspectEntry struct {
+	Path     string `json:"path"`
+	Digest   string `json:"digest"`
+	Platform string `json:"platform,omitempty"`
+	Size     int64  `json:"size"`
+	Mode     string `json:"mode"`
+	Link     string `json:"link,omitempty"`
+}
+
+func statCommand(args []string) *cmderr {
+	if len(args) < 1 {
+		return &cmderr{1, "missing path to package as first argument"}
+	}
+
+	if len(args) < 2 {
+		return &cmderr{1, "missing path of the file in the package as second argument"}
+	}
+
+	pr, cerr := openPackage(args[0], false, nil)
+	if cerr != nil {
+		return cerr
+	}
+	defer pr.Close()
+
+	for {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			return &cmderr{1, fmt.Sprintf("%s does not contain %s", args[0], args[1])}
+		}
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if isMetadata(hdr.Name) {
+			continue
+		}
+
+		e, err := parseEntry(hdr.Name)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if e.path != args[1] {
+			continue
+		}
+
+		field := func(label string, value any) {
+			fmt.Printf("%s %v\n", bold(fmt.Sprintf("%-9s", label+":")), value)
+		}
+
+		field("path", e.path)
+		field("size", hdr.Size)
+		field("mode", fs.FileMode(hdr.Mode))
+		field("digest", e.digest)
+
+		if e.platform() != "" {
+			field("platform", e.platform())
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			field("link", hdr.Linkname)
+		}
+
+		if !hdr.ModTime.IsZero() && hdr.ModTime.Unix() != 0 {
+			field("mtime", hdr.ModTime.UTC().Format(time.RFC3339))
+		}
+
+		return nil
+	}
+}
+
+// fetchPackage downloads the package at url and the checksum file next to it
+// into dir, validates them and returns their paths. Downloads larger than
+// max bytes are aborted.
+func fetchPackage(url, dir string, max int64) (string, string, *cmderr) {
+	base := strings.TrimSuffix(path.Base(url), ".package")
+	pkg := filepath.Join(dir, base+".package")
+	sum := filepath.Join(dir, base+".checksum")
+
+	for p, u := range map[string]string{
+		pkg: url,
+		sum: fmt.Sprintf("%s.checksum", strings.TrimSuffix(url, ".package")),
+	} {
+		b, err := download(u, max)
+
+		if err != nil {
+			return "", "", &cmderr{1, err.Error()}
+		}
+
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			return "", "", &cmderr{1, err.Error()}
+		}
+	}
+
+	if err := validateCommand([]string{pkg, sum}); err != nil {
+		return "", "", err
+	}
+
+	return pkg, sum, nil
+}
+
+// checkDowngrade fails unless the version recorded in the package at pkg is
+// at least the version of the running bin.
+func checkDowngrade(pkg string) *cmderr {
+	if version == "" {
+		return &cmderr{1, "this build of bin has no version to compare to, use --allow-downgrade"}
+	}
+
+	b, cerr := readMetadata(pkg, "version")
+	if cerr != nil {
+		return cerr
+	}
+
+	if b == nil {
+		return &cmderr{1, "the package has no version to compare to, use --allow-downgrade"}
+	}
+
+	current, err := parseSemver(version)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	incoming, err := parseSemver(string(b))
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if incoming.compare(current) < 0 {
+		return &cmderr{1, fmt.Sprintf("refusing to downgrade bin from %s to %s", version, strings.TrimSpace(string(b)))}
+	}
+
+	return nil
+}
+
+func fetchCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	dir := flags.String("download-to", ".", "save the package and its checksum file in `dir`")
+	maxDownload := sizeFlag(defaultMaxDownload)
+	flags.Var(&maxDownload, "max-download-size", "abort downloads larger than `size`, such as 500MB, or 0 for no limit")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing url of package as first argument"}
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	// The download is staged in dir and only moved into place once it was
+	// validated, so a broken download never replaces a good one.
+	tmp, err := os.MkdirTemp(*dir, ".bin-fetch-")
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+	defer removeTemp(tmp)
+
+	pkg, sum, cerr := fetchPackage(args[0], tmp, int64(maxDownload))
+	if cerr != nil {
+		return cerr
+	}
+
+	for _, p := range []string{pkg, sum} {
+		if err := os.Rename(p, filepath.Join(*dir, filepath.Base(p))); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	return nil
+}
+
+func selfUpdateCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	refuseDowngrade := flags.Bool("refuse-downgrade", false, "fail if the package holds an older version of bin")
+	allowDowngrade := flags.Bool("allow-downgrade", false, "update even if --refuse-downgrade would refuse to")
+	maxDownload := sizeFlag(defaultMaxDownload)
+	flags.Var(&maxDownload, "max-download-size", "abort downloads larger than `size`, such as 500MB, or 0 for no limit")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing url of package as first argument"}
+	}
+
+	exe, err := os.Executable()
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	// Everything is staged next to the executable, so it can be renamed
+	// into place and a read-only install location is detected up front.
+	tmp, err := os.MkdirTemp(filepath.Dir(exe), ".bin-update-")
+
+	if err != nil {
+		return &cmderr{1, fmt.Sprintf("cannot update %s: %s", exe, err)}
+	}
+	defer removeTemp(tmp)
+
+	pkg, _, cerr := fetchPackage(args[0], tmp, int64(maxDownload))
+	if cerr != nil {
+		return cerr
+	}
+
+	if *refuseDowngrade && !*allowDowngrade {
+		if err := checkDowngrade(pkg); err != nil {
+			return err
+		}
+	}
+
+	// Like install, only the entries for the running platform and those
+	// without a platform are extracted, so another platform's bin never
+	// replaces this one.
+	paths, cerr := extract(pkg, filepath.Join(tmp, "bin"), extractOptions{platform: runtime.GOOS + "/" + runtime.GOARCH})
+	if cerr != nil {
+		return cerr
+	}
+
+	for _, p := range paths {
+		if filepath.Base(p) != filepath.Base(exe) {
+			continue
+		}
+
+		old := exe + ".old"
+
+		if err := os.Rename(exe, old); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if err := os.Rename(p, exe); err != nil {
+			os.Rename(old, exe)
+			return &cmderr{1, err.Error()}
+		}
+
+		// Removing the old executable fails on Windows while it is still
+		// running, it is left behind in that case.
+		os.Remove(old)
+		return nil
+	}
+
+	return &cmderr{1, fmt.Sprintf("package does not contain %s", filepath.Base(exe))}
+}
+
+func explainCommand(args []string) *cmderr {
+	if len(args) < 1 {
+		return &cmderr{1, "missing entry name as first argument"}
+	}
+
+	if isMetadata(args[0]) {
+		fmt.Printf("%s %s\n", bold("metadata:"), strings.TrimPrefix(args[0], metadataPrefix))
+		return nil
+	}
+
+	e, err := parseEntry(args[0])
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	algorithm, digest, _ := strings.Cut(e.digest, ":")
+
+	fmt.Printf("%s %s\n", bold("algorithm:"), algorithm)
+	fmt.Printf("%s    %s\n", bold("digest:"), digest)
+
+	if e.platform() != "" {
+		fmt.Printf("%s        %s\n", bold("os:"), e.os)
+		fmt.Printf("%s      %s\n", bold("arch:"), e.arch)
+	}
+
+	fmt.Printf("%s      %s\n", bold("path:"), e.path)
+	return nil
+}
+
+func packageCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("package", flag.ContinueOnError)
+	var deps listFlag
+	flags.Var(&deps, "dep", "declare a dependency on the package `name@checksum`, may be repeated")
+	version := flags.String("version", "", "record `version` in the package")
+	dedup := flags.Bool("dedup", false, "store the content of identical inputs only once")
+	includeChecksum := flags.Bool("include-checksum-in-name", false, "append a short checksum of the package to the output names")
+	entriesFrom := flags.String("entries-from-file", "", "package the files listed in `file`, one path[:installname] per line")
+	output := flags.String("o", "", "write the package to `path` instead of next to the first binary")
+	packageName := flags.String("package-name", "", "name the package `name`.package instead of after the first binary")
+	comment := flags.String("package-comment", "", "embed `text`, such as release notes, in the package")
+	uname := flags.String("uname", "", "record `name` as the owner of every entry")
+	gname := flags.String("gname", "", "record `name` as the group of every entry")
+	uid := flags.Int("uid", 0, "record `id` as the owner id of every entry")
+	gid := flags.Int("gid", 0, "record `id` as the group id of every entry")
+	fromURL := flags.String("package-from-url", "", "download the binary to package from `url`")
+	inputName := flags.String("name", "", "install the binary read from stdin or downloaded with --package-from-url as `path`")
+	normalizeNewlines := flags.Bool("normalize-newlines", false, "convert CRLF line endings to LF in the files matched by --text-glob")
+	textGlob := flags.String("text-glob", "", "comma separated `patterns` of text files, binaries must never match them")
+	modeFrom := flags.String("mode", "source", "record the permissions of each `source` file or fixed:<octal> for every entry")
+	defaultMode := flags.String("entry-mode-default", "0755", "use `mode` for binaries without permission bits, such as those read from stdin")
+	expectSHA256 := flags.String("expect-sha256", "", "fail unless the download has the sha256 `digest`")
+	platform := flags.String("platform", "", "tag every binary as belonging on `os/arch`")
+	preserveXattrs := flags.Bool("preserve-xattrs", false, "record the extended attributes of the binaries")
+	format := flags.String("format", "native", "write a native package or, experimentally, an oci image layout")
+	selfCheck := flags.Bool("validate-before-package", false, "read back and validate the written package before reporting success")
+	fullScan := flags.Bool("full-scan", false, "with --validate-before-package, check every entry and report all that do not match")
+	noAtomic := flags.Bool("no-atomic", false, "write the output in place instead of renaming a temporary file into place")
+	printPath := flags.Bool("print-path", false, "print the path of the package once it is written")
+	writeIndex := flags.Bool("write-index", false, "start the package with an index of its entries")
+	modtime := flags.Int64("package-modtime", 0, "record `seconds` since the epoch as the modification time in the gzip header")
+	formatName := flags.String("tar-format", "pax", "write tar headers in the `pax`, gnu or ustar format")
+	sortBy := flags.String("sort", "", "order the entries by `name` instead of the order of the arguments")
+	nameCase := flags.String("entry-name-case", "preserve", "`preserve` the case of entry names or make them lower case")
+	var splitSize sizeFlag
+	flags.Var(&splitSize, "split-size", "split the package into parts of at most `size`, such as 100MB, listed in <package>.manifest")
+	sinceCommit := flags.String("since-commit", "", "package the binaries whose sources changed since the git commit `ref`")
+	var sourceMaps listFlag
+	flags.Var(&sourceMaps, "source-map", "with --since-commit, map changed sources to binaries as `source=binary`, such as cmd/*=build/*, may be repeated")
+	separate := flags.Bool("separate", false, "write one package per binary instead of a single package")
+	concurrency := flags.Int("concurrent-package", 1, "with --separate, write up to `N` packages at a time")
+	failEmpty := flags.Bool("fail-empty-package", true, "refuse to write a package without any binaries")
+	skipExisting := flags.Bool("skip-existing", false, "leave existing output untouched if its checksum is unchanged")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if *separate {
+		if *output != "" || *packageName != "" || *entriesFrom != "" || *fromURL != "" || slices.Contains(args, "-") {
+			return &cmderr{1, "--separate only packages binaries given as arguments and names each package after its binary"}
+		}
+
+		return packageSeparately(flags, args, *concurrency)
+	}
+
+	if *preserveXattrs {
+		warnXattrs()
+	}
+
+	if *packageName != "" && *output != "" {
+		return &cmderr{1, "--package-name and -o are mutually exclusive"}
+	}
+
+	if strings.ContainsAny(*packageName, `/\`) {
+		return &cmderr{1, "--package-name must not contain a path separator"}
+	}
+
+	var inputs []input
+	for _, arg := range args {
+		if arg != "-" {
+			inputs = append(inputs, input{path: arg, name: arg})
+			continue
+		}
+
+		if *inputName == "" {
+			return &cmderr{1, "packaging stdin requires --name"}
+		}
+
+		b, err := io.ReadAll(os.Stdin)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		inputs = append(inputs, input{path: *inputName, name: *inputName, data: b})
+	}
+
+	if *fromURL != "" {
+		b, err := download(*fromURL, defaultMaxDownload)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if *expectSHA256 != "" && checksum(b) != "sha256:"+strings.TrimPrefix(*expectSHA256, "sha256:") {
+			return &cmderr{1, fmt.Sprintf("invalid checksum for %s", *fromURL)}
+		}
+
+		name := *inputName
+		if name == "" {
+			name = path.Base(*fromURL)
+		}
+
+		inputs = append(inputs, input{path: name, name: name, data: b})
+	}
+
+	if *entriesFrom != "" {
+		listed, err := readEntries(*entriesFrom)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		inputs = append(inputs, listed...)
+	}
+
+	if *sinceCommit != "" {
+		if len(sourceMaps) < 1 {
+			return &cmderr{1, "--since-commit requires at least one --source-map"}
+		}
+
+		changed, err := changedSince(*sinceCommit)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		binaries, err := mapSources(changed, sourceMaps)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		for _, b := range binaries {
+			inputs = append(inputs, input{path: b, name: b})
+		}
+	}
+
+	if len(args) < 1 && *fromURL == "" && *entriesFrom == "" && *sinceCommit == "" {
+		return &cmderr{1, "missing path to binaries as arguments"}
+	}
+
+	if len(inputs) < 1 && *failEmpty {
+		return &cmderr{1, "no binaries to package, refusing to write an empty package"}
+	}
+
+	if len(inputs) < 1 && *output == "" && *packageName == "" {
+		return &cmderr{1, "an empty package requires -o or --package-name"}
+	}
+
+	if *platform != "" {
+		if err := parsePlatform(*platform); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	fallbackMode, err := strconv.ParseUint(*defaultMode, 8, 32)
+
+	if err != nil || fallbackMode > 0777 {
+		return &cmderr{1, fmt.Sprintf("%s is not a valid mode", *defaultMode)}
+	}
+
+	if *normalizeNewlines {
+		if *textGlob == "" {
+			return &cmderr{1, "--normalize-newlines requires --text-glob"}
+		}
+
+		for i, in := range inputs {
+			text, err := matchAny(strings.Split(*textGlob, ","), path.Base(in.name))
+
+			if err != nil {
+				return &cmderr{1, err.Error()}
+			}
+
+			inputs[i].text = text
+		}
+	}
+
+	if *modeFrom != "source" {
+		fixed, ok := strings.CutPrefix(*modeFrom, "fixed:")
+		perm, err := strconv.ParseUint(fixed, 8, 32)
+
+		if !ok || err != nil || perm == 0 || perm > 0777 {
+			return &cmderr{1, fmt.Sprintf("%s is not a valid mode, expected source or fixed:<octal>", *modeFrom)}
+		}
+
+		for i := range inputs {
+			inputs[i].perm = fs.FileMode(perm)
+		}
+	}
+
+	tarFormat, ok := tarFormats[*formatName]
+
+	if !ok {
+		return &cmderr{1, fmt.Sprintf("%s is not a supported tar format, expected pax, gnu or ustar", *formatName)}
+	}
+
+	switch *nameCase {
+	case "preserve":
+	case "lower":
+		// packaged maps each normalized name to the input packaged as it, so
+		// names only differing in case are caught.
+		packaged := map[string]string{}
+
+		for i, in := range inputs {
+			name := strings.ToLower(in.name)
+
+			if other, ok := packaged[name]; ok {
+				return &cmderr{1, fmt.Sprintf("%s and %s would both be packaged as %s", other, in.name, name)}
+			}
+
+			packaged[name] = in.name
+			inputs[i].name = name
+		}
+	default:
+		return &cmderr{1, fmt.Sprintf("%s is not a valid entry name case, expected preserve or lower", *nameCase)}
+	}
+
+	switch *sortBy {
+	case "":
+	case "name":
+		sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].name < inputs[j].name })
+	default:
+		return &cmderr{1, fmt.Sprintf("%s is not a supported sort order, expected name", *sortBy)}
+	}
+
+	switch *format {
+	case "native":
+	case "oci":
+		if *output == "" {
+			return &cmderr{1, "--format oci requires -o with the directory to write the image layout to"}
+		}
+
+		// The image config must name the platform, which is the one the
+		// binaries were built for rather than the one packaging them.
+		if *platform == "" {
+			return &cmderr{1, "--format oci requires --platform with the os/arch the binaries are built for"}
+		}
+
+		if err := writeOCI(*output, inputs, fs.FileMode(fallbackMode), *platform); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		return nil
+	default:
+		return &cmderr{1, fmt.Sprintf("%s is not a supported format, expected native or oci", *format)}
+	}
+
+	for _, d := range deps {
+		if _, err := parseDep(d); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	// first maps the checksum of each distinct input to the name of the entry
+	// holding its content, for --dedup.
+	first := map[string]string{}
+	var files []packagedFile
+
+	for _, in := range inputs {
+		b, mode, err := in.read()
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if mode.Perm() == 0 {
+			mode |= fs.FileMode(fallbackMode)
+		}
+
+		sum := checksum(b)
+		header := &tar.Header{
+			Name:   entryName(sum, *platform, in.name),
+			Mode:   int64(mode),
+			Size:   int64(len(b)),
+			Uname:  *uname,
+			Format: tarFormat,
+			Gname:  *gname,
+			Uid:    *uid,
+			Gid:    *gid,
+		}
+
+		if *preserveXattrs && in.data == nil {
+			attrs, err := getXattrs(in.path)
+
+			if err != nil {
+				return &cmderr{1, fmt.Sprintf("%s: %s", in.path, err)}
+			}
+
+			header.PAXRecords = xattrRecords(attrs)
+		}
+
+		if name, ok := first[sum]; ok && *dedup {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = name
+			header.Size = 0
+			files = append(files, packagedFile{header, nil})
+			continue
+		}
+
+		first[sum] = header.Name
+		files = append(files, packagedFile{header, b})
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	// Without --package-modtime the gzip header records no modification
+	// time, so identical inputs always produce identical packages.
+	if *modtime > 0 {
+		gw.Header.ModTime = time.Unix(*modtime, 0)
+	}
+
+	if *writeIndex {
+		index, err := json.Marshal(newPackageIndex(files))
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if err := writeMetadata(tw, tarFormat, "index", index); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if *comment != "" {
+		if err := writeMetadata(tw, tarFormat, "comment", []byte(*comment+"\n")); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if *version != "" {
+		if err := writeMetadata(tw, tarFormat, "version", []byte(*version+"\n")); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if len(deps) > 0 {
+		if err := writeMetadata(tw, tarFormat, "deps", []byte(strings.Join(deps, "\n")+"\n")); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	for _, f := range files {
+		if err := tw.WriteHeader(f.header); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if _, err := tw.Write(f.data); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if err := gw.Close(); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	b := buf.Bytes()
+	sum := checksum(b)
+	var name string
+
+	switch {
+	case *output != "":
+		name = strings.TrimSuffix(*output, ".package")
+	case *packageName != "" && len(inputs) > 0:
+		name = filepath.Join(filepath.Dir(inputs[0].path), *packageName)
+	case *packageName != "":
+		name = *packageName
+	default:
+		name = inputs[0].path
+	}
+
+	if *includeChecksum {
+		name = fmt.Sprintf("%s-%s", name, strings.TrimPrefix(sum, "sha256:")[:12])
+	}
+
+	pkg := fmt.Sprintf("%s.package", name)
+
+	// A split package is written as a manifest listing its parts.
+	existing := pkg
+	if splitSize > 0 {
+		existing = pkg + splitSuffix
+	}
+
+	if *skipExisting && upToDate(existing, name, sum) {
+		fmt.Printf("%s is up to date, skipped.\n", existing)
+		return nil
+	}
+
+	write := writeFileAtomic
+	if *noAtomic {
+		write = os.WriteFile
+	}
+
+	if splitSize > 0 {
+		manifest, err := writeSplit(pkg, b, int64(splitSize), write)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		pkg = manifest
+	} else if err := write(pkg, b, 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if err := write(fmt.Sprintf("%s.checksum", name), []byte(fmt.Sprintf("%s\n", sum)), 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if *selfCheck {
+		if err := validateCommand([]string{pkg, fmt.Sprintf("%s.checksum", name)}); err != nil {
+			return &cmderr{1, fmt.Sprintf("%s failed validation after packaging: %s", pkg, err.reason)}
+		}
+
+		if err := verifyEntries(pkg, *fullScan); err != nil {
+			return &cmderr{1, fmt.Sprintf("%s failed validation after packaging: %s", pkg, err.reason)}
+		}
+	}
+
+	if *printPath {
+		fmt.Println(pkg)
+	}
+
+	return nil
+}
+
+// forwardFlags returns the flags set on flags as arguments, except those
+// named in skip, so a command can run itself again with the same flags.
+func forwardFlags(flags *flag.FlagSet, skip ...string) []string {
+	var args []string
+
+	flags.Visit(func(f *flag.Flag) {
+		if slices.Contains(skip, f.Name) {
+			return
+		}
+
+		if l, ok := f.Value.(*listFlag); ok {
+			for _, v := range *l {
+				args = append(args, fmt.Sprintf("-%s=%s", f.Name, v))
+			}
+			return
+		}
+
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+
+	return args
+}
+
+// packageSeparately packages every binary in paths on its own, up to jobs at
+// a time, with the flags set on flags other than those selecting this mode.
+func packageSeparately(flags *flag.FlagSet, paths []string, jobs int) *cmderr {
+	if len(paths) < 1 {
+		return &cmderr{1, "missing path to binaries as arguments"}
+	}
+
+	shared := forwardFlags(flags, "separate", "concurrent-package")
+	errs := make([]*cmderr, len(paths))
+
+	parallel(jobs, len(paths), func(i int) {
+		errs[i] = packageCommand(append(slices.Clone(shared), "--", paths[i]))
+	})
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paths[i], err.reason)
+		}
+	}
+
+	if failed > 0 {
+		return &cmderr{1, fmt.Sprintf("%d of %d packages failed", failed, len(paths))}
+	}
+
+	return nil
+}
+
+// verifyEntries reads every entry of the package at pkg and checks that its
+// content matches the checksum in its name. It stops at the first mismatch
+// unless fullScan is set, in which case all mismatching entries are reported.
+func verifyEntries(pkg string, fullScan bool) *cmderr {
+	pr, cerr := openPackage(pkg, false, nil)
+	if cerr != nil {
+		return cerr
+	}
+	defer pr.Close()
+
+	var corrupt []string
+
+	for {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if isMetadata(hdr.Name) || hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+
+		e, err := parseEntry(hdr.Name)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		b, err := io.ReadAll(pr)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if checksum(b) == e.digest {
+			continue
+		}
+
+		if !fullScan {
+			return &cmderr{1, fmt.Sprintf("invalid checksum for %s", e.path)}
+		}
+
+		corrupt = append(corrupt, e.path)
+	}
+
+	if len(corrupt) > 0 {
+		return &cmderr{1, fmt.Sprintf("invalid checksum for %d entries: %s", len(corrupt), strings.Join(corrupt, ", "))}
+	}
+
+	return nil
+}
+
+// countEntries returns the number of binaries in the package at pkg, not
+// counting its metadata.
+func countEntries(pkg string) (int, *cmderr) {
+	pr, cerr := openPackage(pkg, false, nil)
+	if cerr != nil {
+		return 0, cerr
+	}
+	defer pr.Close()
+
+	n := 0
+
+	for {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, &cmderr{1, err.Error()}
+		}
+
+		if !isMetadata(hdr.Name) {
+			n++
+		}
+	}
+}
+
+// checkGzip reads the package at pkg to the end, so gzip checks its content
+// against the crc32 and length stored in its trailer. This is much cheaper
+// than validating the checksum but does not catch a package that was
+// replaced as a whole.
+func checkGzip(pkg string) *cmderr {
+	file, _, err := openPackageFile(pkg)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+	defer file.Close()
+
+	gr, err := gzip.NewReader(file)
+
+	if err != nil {
+		return &cmderr{1, fmt.Sprintf("%s: %s", pkg, err)}
+	}
+
+	if _, err := io.Copy(io.Discard, gr); err != nil {
+		return &cmderr{1, fmt.Sprintf("%s: %s", pkg, err)}
+	}
+
+	return nil
+}
+
+// upToDate reports whether the package written to pkg exists and its
+// checksum file name.checksum already holds sum.
+func upToDate(pkg, name, sum string) bool {
+	if _, err := os.Stat(pkg); err != nil {
+		return false
+	}
+
+	b, err := os.ReadFile(fmt.Sprintf("%s.checksum", name))
+
+	return err == nil && strings.TrimSpace(string(b)) == sum
+}
+
+// input is a file to package and the path it is installed as.
+// Inputs that do not come from a file, such as downloads, carry their
+// content and mode in data and mode instead.
+type input struct {
+	path string
+	name string
+	data []byte
+	mode fs.FileMode
+	// perm replaces the permission bits of the input unless it is zero.
+	perm fs.FileMode
+	// text converts CRLF line endings to LF.
+	text bool
+}
+
+func (in input) read() ([]byte, fs.FileMode, error) {
+	b, mode := in.data, in.mode
+
+	if b == nil {
+		stat, err := os.Stat(in.path)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if b, err = os.ReadFile(in.path); err != nil {
+			return nil, 0, err
+		}
+
+		mode = stat.Mode()
+	}
+
+	if in.perm != 0 {
+		mode = mode&^fs.ModePerm | in.perm
+	}
+
+	if in.text {
+		b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	}
+
+	return b, mode, nil
+}
+
+// matchAny reports whether name matches any of the path.Match patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := path.Match(strings.TrimSpace(p), name)
+
+		if err != nil {
+			return false, fmt.Errorf("%s is not a valid pattern", p)
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// readEntries reads the inputs listed in the file at path. Each non-empty line
+// holds the path of a file, optionally followed by a colon and the path to
+// install it as.
+func readEntries(path string) ([]input, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []input
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		p, name, ok := strings.Cut(line, ":")
+		if !ok {
+			name = p
+		}
+
+		if p == "" || name == "" {
+			return nil, fmt.Errorf("%s: malformed entry %q", path, line)
+		}
+
+		inputs = append(inputs, input{path: p, name: name})
+	}
+
+	return inputs, nil
+}
+
+func checksumCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("checksum", flag.ContinueOnError)
+	compat := flags.Bool("sha256sum-compat", false, "print the checksum in the format of sha256sum")
+	binary := flags.Bool("binary", false, "mark the file as binary in sha256sum-compat output")
+	algorithm := flags.String("algorithm", "sha256", "hash the binary with `algorithm`, sha256 or sha512")
+	prefix := flags.String("checksum-prefix", "", "print the digest after `prefix` instead of <algorithm>:")
+	var bufSize sizeFlag
+	flags.Var(&bufSize, "hash-buffer-size", "read the binary in chunks of `size`, such as 1MiB")
+	jobs := flags.Int("concurrent-checksum", 1, "hash up to `N` binaries at a time")
+	recursive := flags.Bool("recursive", false, "checksum every file below the directory given as first argument")
+	manifest := flags.String("manifest", "", "with --recursive, write the checksums to the manifest at `path` and print its checksum")
+	manifestChecksum := flags.Bool("manifest-checksum", false, "with --recursive, also write the checksum of the manifest to <manifest>.checksum")
+	set := flags.Bool("set", false, "print a single checksum over the checksums of every package below the directory given as first argument")
+	outputFormat := flags.String("output-format", "plain", "print the checksum as a `plain` digest or as an env assignment for shell scripts")
+	varName := flags.String("var", "CHECKSUM", "assign the checksum to the variable `name` with --output-format env")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing path to binary as first argument"}
+	}
+
+	if bufSize != 0 && (bufSize < minHashBuffer || bufSize > maxHashBuffer) {
+		return &cmderr{1, fmt.Sprintf("hash-buffer-size must be between %s and %s", formatBytes(minHashBuffer), formatBytes(maxHashBuffer))}
+	}
+
+	switch *outputFormat {
+	case "plain":
+	case "env":
+		if !isEnvName(*varName) {
+			return &cmderr{1, fmt.Sprintf("%s is not a valid variable name", *varName)}
+		}
+
+		if len(args) > 1 || *compat || *recursive || *set {
+			return &cmderr{1, "--output-format env takes a single binary"}
+		}
+	default:
+		return &cmderr{1, fmt.Sprintf("%s is not a valid output format, expected plain or env", *outputFormat)}
+	}
+
+	if *set {
+		sum, err := setChecksum(args[0])
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		fmt.Println(sum)
+		return nil
+	}
+
+	if *recursive {
+		if *manifest == "" {
+			return &cmderr{1, "--recursive requires --manifest"}
+		}
+
+		sum, err := writeManifest(args[0], *manifest, *algorithm, int(bufSize))
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if *manifestChecksum {
+			if err := writeFileAtomic(*manifest+".checksum", []byte(sum+"\n"), 0644); err != nil {
+				return &cmderr{1, err.Error()}
+			}
+		}
+
+		fmt.Println(sum)
+		return nil
+	}
+
+	sums := make([]string, len(args))
+	errs := make([]error, len(args))
+
+	parallel(*jobs, len(args), func(i int) {
+		sums[i], errs[i] = checksumFile(*algorithm, args[i], int(bufSize))
+	})
+
+	mode := " "
+	if *binary {
+		mode = "*"
+	}
+
+	for i, sum := range sums {
+		if errs[i] != nil {
+			return &cmderr{1, errs[i].Error()}
+		}
+
+		if *compat {
+			fmt.Printf("%s %s%s\n", strings.TrimPrefix(sum, *algorithm+":"), mode, args[i])
+			continue
+		}
+
+		if *prefix != "" {
+			sum = *prefix + strings.TrimPrefix(sum, *algorithm+":")
+		}
+
+		if *outputFormat == "env" {
+			fmt.Printf("%s=%s\n", *varName, sum)
+			continue
+		}
+
+		// A single binary keeps the bare digest output checksum files are
+		// made of.
+		if len(args) == 1 {
+			fmt.Println(sum)
+			continue
+		}
+
+		fmt.Printf("%s  %s\n", sum, args[i])
+	}
+
+	return nil
+}
+
+// isEnvName reports whether s can be used as a shell variable name.
+func isEnvName(s string) bool {
+	for i, r := range s {
+		if r != '_' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+func validateCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	expect := flags.String("expect-algorithm", "", "fail unless the checksum uses `algorithm` or a stronger one")
+	size := flags.Int64("verify-size", -1, "fail unless the binary is exactly `N` bytes")
+	jobs := flags.Int("concurrent-validate", 1, "validate up to `N` files of a directory at a time")
+	prefix := flags.String("checksum-prefix", "", "expect the digest after `prefix` instead of <algorithm>:")
+	scrubDir := flags.String("scrub", "", "validate every package below `dir` against its checksum file")
+	deep := flags.Bool("deep", false, "also check every entry of a package against its own checksum")
+	onlyChanged := flags.Bool("only-changed", false, "with --scrub, skip packages unchanged since a previous run found them healthy")
+	full := flags.Bool("full", false, "with --only-changed, validate every package anyway")
+	match := flags.String("match", "exact", "compare the digest `exact`ly or accept a prefix of it")
+	entries := flags.Int("entries", -1, "fail unless the package holds exactly `N` binaries")
+	report := flags.String("json-report", "", "when validating a directory, write the result of every file as JSON to `path`")
+	watch := flags.Bool("follow", false, "validate again every --interval until interrupted, printing the result when it changes")
+	interval := flags.Duration("interval", time.Minute, "wait `duration` between validations with --follow")
+	abortFirst := flags.Bool("abort-on-first-mismatch", true, "with --deep, stop at the first entry that does not match its checksum")
+	fullScan := flags.Bool("full-scan", false, "with --deep, check every entry and report all that do not match")
+	gzipCRC := flags.Bool("gzip-crc", false, "only check the package against the crc32 and length in its gzip trailer")
+	set := flags.Bool("set", false, "check the checksum over every package below the directory given as first argument against the second")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if *expect != "" && strength(*expect) < 0 {
+		return &cmderr{1, fmt.Sprintf("%s is not a supported checksum algorithm", *expect)}
+	}
+
+	if *match != "exact" && *match != "prefix" {
+		return &cmderr{1, fmt.Sprintf("%s is not a valid match mode, expected exact or prefix", *match)}
+	}
+
+	if *watch {
+		if *interval <= 0 {
+			return &cmderr{1, "interval must be positive"}
+		}
+
+		shared := forwardFlags(flags, "follow", "interval")
+
+		return follow(*interval, func() *cmderr {
+			return validateCommand(append(append(slices.Clone(shared), "--"), args...))
+		})
+	}
+
+	if *scrubDir != "" {
+		return scrub(*scrubDir, *deep, *fullScan || !*abortFirst, *jobs, *onlyChanged, *full)
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing path to binary as first argument"}
+	}
+
+	if *gzipCRC {
+		return checkGzip(args[0])
+	}
+
+	if *set {
+		if len(args) < 2 {
+			return &cmderr{1, "missing expected checksum of the package set as second argument"}
+		}
+
+		return validateSet(args[0], args[1])
+	}
+
+	if len(args) < 2 {
+		sum, cerr := findChecksum(packageBase(args[0]))
+		if cerr != nil {
+			return cerr
+		}
+
+		args = append(args, sum)
+	}
+
+	if stat, err := os.Stat(args[0]); err == nil && stat.IsDir() {
+		return validateManifest(args[0], args[1], *jobs, *report)
+	}
+
+	bin, _, err := openPackageFile(args[0])
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+	defer bin.Close()
+
+	ab, err := io.ReadAll(bin)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if *size >= 0 && int64(len(ab)) != *size {
+		return &cmderr{1, fmt.Sprintf("invalid size for binary, got %d bytes, expected %d", len(ab), *size)}
+	}
+
+	checksumFile, err := os.Open(args[1])
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	bb, err := io.ReadAll(checksumFile)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	content := string(bb)
+
+	if *prefix != "" {
+		digest, ok := strings.CutPrefix(strings.TrimSpace(content), *prefix)
+
+		if !ok {
+			return &cmderr{1, fmt.Sprintf("checksum does not start with %s", *prefix)}
+		}
+
+		content = digest
+	}
+
+	algorithm, digest, err := parseChecksum(content)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if *expect != "" && strength(algorithm) < strength(*expect) {
+		return &cmderr{1, fmt.Sprintf("checksum uses %s but at least %s is required", algorithm, *expect)}
+	}
+
+	sum, err := checksumWith(algorithm, ab)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	switch *match {
+	case "exact":
+		if sum != fmt.Sprintf("%s:%s", algorithm, digest) {
+			return &cmderr{1, "invalid checksum for binary"}
+		}
+	case "prefix":
+		if len(digest) < minDigestPrefix {
+			return &cmderr{1, fmt.Sprintf("checksum prefix is too short, at least %d characters are required", minDigestPrefix)}
+		}
+
+		if !strings.HasPrefix(sum, fmt.Sprintf("%s:%s", algorithm, strings.ToLower(digest))) {
+			return &cmderr{1, "invalid checksum for binary"}
+		}
+	}
+
+	if *entries >= 0 {
+		n, cerr := countEntries(args[0])
+		if cerr != nil {
+			return cerr
+		}
+
+		if n != *entries {
+			return &cmderr{1, fmt.Sprintf("package holds %d binaries, expected %d", n, *entries)}
+		}
+	}
+
+	if *deep {
+		return verifyEntries(args[0], *fullScan || !*abortFirst)
+	}
+
+	return nil
+}
+
+// minDigestPrefix is the shortest digest prefix validate --match prefix
+// accepts, shorter prefixes are too easy to collide with.
+const minDigestPrefix = 12
+
+// checksumSuffixes are the conventional suffixes of checksum files, in the
+// order they are looked for.
+var checksumSuffixes = []string{".checksum", ".sha256", ".sha512"}
+
+// findChecksum returns the path of the checksum file for base, the first of
+// base with one of the checksumSuffixes that exists.
+func findChecksum(base string) (string, *cmderr) {
+	var tried []string
+
+	for _, suffix := range checksumSuffixes {
+		p := base + suffix
+
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+
+		tried = append(tried, p)
+	}
+
+	return "", &cmderr{1, fmt.Sprintf("no checksum found, looked for %s", strings.Join(tried, ", "))}
+}
+
+// parseChecksum parses the content of a checksum file. Besides the
+// <algorithm>:<digest> form written by bin it accepts the <digest>  <name>
+// form of sha256sum and sha512sum, telling the algorithm by the digest length.
+func parseChecksum(s string) (algorithm, digest string, err error) {
+	fields := strings.Fields(s)
+
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("empty checksum")
+	}
+
+	if algorithm, digest, ok := strings.Cut(fields[0], ":"); ok {
+		return algorithm, digest, nil
+	}
+
+	switch len(fields[0]) {
+	case sha256.Size * 2:
+		return "sha256", fields[0], nil
+	case sha512.Size * 2:
+		return "sha512", fields[0], nil
+	}
+
+	return "", "", fmt.Errorf("malformed checksum, expected <algorithm>:<digest>")
 }
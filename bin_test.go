@@ -0,0 +1,970 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inTempDir runs the test from a fresh temporary directory, since commands
+// read and write paths such as .bin relative to the working directory.
+func inTempDir(t testing.TB) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t testing.TB, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	f()
+	w.Close()
+
+	return <-out
+}
+
+func writeFile(t testing.TB, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePackage writes a package named name holding entries, with a checksum
+// file next to it, for entries the package command would never write.
+func writePackage(t *testing.T, name string, entries []packagedFile) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	for _, e := range entries {
+		if e.header.Typeflag == tar.TypeReg {
+			e.header.Size = int64(len(e.data))
+		}
+
+		if err := tw.WriteHeader(e.header); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, name+".package", buf.String())
+	writeFile(t, name+".checksum", checksum(buf.Bytes())+"\n")
+}
+
+// entryFile returns a regular package entry for path holding content.
+func entryFile(path, content string, mode int64) packagedFile {
+	return platformFile("", path, content, mode)
+}
+
+// platformFile returns a regular package entry for path tagged with
+// platform.
+func platformFile(platform, path, content string, mode int64) packagedFile {
+	return packagedFile{
+		&tar.Header{Name: entryName(checksum([]byte(content)), platform, path), Mode: mode, Typeflag: tar.TypeReg},
+		[]byte(content),
+	}
+}
+
+// withStdin makes os.Stdin read content for the rest of the test.
+func withStdin(t *testing.T, content string) {
+	t.Helper()
+
+	path := t.TempDir() + "/stdin"
+	writeFile(t, path, content)
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = f
+
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+// readHeaders returns the tar headers of the entries of the package at pkg.
+func readHeaders(t *testing.T, pkg string) []*tar.Header {
+	t.Helper()
+
+	pr, cerr := openPackage(pkg, false, nil)
+	if cerr != nil {
+		t.Fatal(cerr.reason)
+	}
+	defer pr.Close()
+
+	var headers []*tar.Header
+
+	for {
+		hdr, err := pr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		headers = append(headers, hdr)
+	}
+}
+
+// assertFile fails the test unless the file at path holds content.
+func assertFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != content {
+		t.Errorf("%s holds %q, want %q", path, b, content)
+	}
+}
+
+// listDir returns the paths of the files below dir, relative to it.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var paths []string
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return paths
+}
+
+// run calls a command with args and fails the test if it fails.
+func run(t testing.TB, command func([]string) *cmderr, args ...string) string {
+	t.Helper()
+
+	var err *cmderr
+	out := captureStdout(t, func() { err = command(args) })
+
+	if err != nil {
+		t.Fatalf("%v: %s", args, err.reason)
+	}
+
+	return out
+}
+
+func TestChecksumMultipleArguments(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "a", "a\n")
+	writeFile(t, "b", "b\n")
+
+	sumA := checksum([]byte("a\n"))
+	sumB := checksum([]byte("b\n"))
+
+	if out := run(t, checksumCommand, "a"); out != sumA+"\n" {
+		t.Errorf("checksum a printed %q, want the bare digest %q", out, sumA)
+	}
+
+	want := sumA + "  a\n" + sumB + "  b\n"
+
+	if out := run(t, checksumCommand, "a", "b"); out != want {
+		t.Errorf("checksum a b printed %q, want %q", out, want)
+	}
+
+	if out := run(t, checksumCommand, "--concurrent-checksum", "2", "a", "b"); out != want {
+		t.Errorf("checksum --concurrent-checksum 2 a b printed %q, want %q", out, want)
+	}
+
+	if err := checksumCommand([]string{"a", "missing"}); err == nil || !strings.Contains(err.reason, "missing") {
+		t.Errorf("checksum a missing returned %v, want an error naming missing", err)
+	}
+}
+
+func TestPackageDedup(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "a", "same\n")
+	writeFile(t, "b", "same\n")
+
+	run(t, packageCommand, "--dedup", "-o", "p", "a", "b")
+
+	headers := readHeaders(t, "p.package")
+
+	if len(headers) != 2 {
+		t.Fatalf("package holds %d entries, want 2", len(headers))
+	}
+
+	if headers[0].Typeflag != tar.TypeReg || headers[0].Size != 5 {
+		t.Errorf("first entry is not a regular file holding the content")
+	}
+
+	if headers[1].Typeflag != tar.TypeLink || headers[1].Linkname != headers[0].Name || headers[1].Size != 0 {
+		t.Errorf("second entry is not a link to %s", headers[0].Name)
+	}
+
+	run(t, installCommand, "p.package")
+
+	assertFile(t, ".bin/a", "same\n")
+	assertFile(t, ".bin/b", "same\n")
+}
+
+func TestInstallAtomicRollback(t *testing.T) {
+	inTempDir(t)
+
+	if err := os.Mkdir(".bin", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, ".bin/x", "old\n")
+
+	// y is world-writable, so --strict-permissions fails the install after
+	// x was already extracted.
+	writePackage(t, "p", []packagedFile{
+		entryFile("x", "new\n", 0755),
+		entryFile("y", "y\n", 0666),
+	})
+
+	if err := installCommand([]string{"--atomic", "--strict-permissions", "p.package"}); err == nil {
+		t.Fatal("install of a world-writable entry with --strict-permissions succeeded")
+	}
+
+	assertFile(t, ".bin/x", "old\n")
+
+	if _, err := os.Stat(".bin/y"); !os.IsNotExist(err) {
+		t.Errorf(".bin/y exists after a failed atomic install")
+	}
+
+	files, err := os.ReadDir(".")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if strings.Contains(f.Name(), "staging") {
+			t.Errorf("staging directory %s was left behind", f.Name())
+		}
+	}
+}
+
+func TestValidateSha256Fallback(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "app", "app\n")
+
+	sum := checksum([]byte("app\n"))
+	digest := strings.TrimPrefix(sum, "sha256:")
+
+	// The format written by sha256sum.
+	writeFile(t, "app.sha256", digest+"  app\n")
+	run(t, validateCommand, "app")
+
+	writeFile(t, "app.sha256", strings.Repeat("0", len(digest))+"  app\n")
+
+	if err := validateCommand([]string{"app"}); err == nil {
+		t.Error("validate accepted a .sha256 file with the wrong digest")
+	}
+
+	// A .checksum file is preferred over the .sha256 one.
+	writeFile(t, "app.checksum", sum+"\n")
+	run(t, validateCommand, "app")
+
+	if err := os.Remove("app.checksum"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove("app.sha256"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateCommand([]string{"app"}); err == nil || !strings.Contains(err.reason, "app.sha256") {
+		t.Errorf("validate without a checksum file returned %v, want an error listing app.sha256", err)
+	}
+}
+
+func TestInstallSha256Fallback(t *testing.T) {
+	inTempDir(t)
+	writePackage(t, "p", []packagedFile{entryFile("x", "x\n", 0755)})
+
+	if err := os.Rename("p.checksum", "p.sha256"); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, installCommand, "p.package")
+	assertFile(t, ".bin/x", "x\n")
+}
+
+func TestChecksumPrefixRoundTrip(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "app", "app\n")
+
+	out := run(t, checksumCommand, "--checksum-prefix", "sha256=", "app")
+	want := "sha256=" + strings.TrimPrefix(checksum([]byte("app\n")), "sha256:") + "\n"
+
+	if out != want {
+		t.Fatalf("checksum --checksum-prefix sha256= printed %q, want %q", out, want)
+	}
+
+	writeFile(t, "app.checksum", out)
+	run(t, validateCommand, "--checksum-prefix", "sha256=", "app", "app.checksum")
+
+	if err := validateCommand([]string{"app", "app.checksum"}); err == nil {
+		t.Error("validate without --checksum-prefix accepted a sha256= checksum")
+	}
+
+	writeFile(t, "app.checksum", strings.Replace(out, "=", ":", 1))
+
+	if err := validateCommand([]string{"--checksum-prefix", "sha256=", "app", "app.checksum"}); err == nil {
+		t.Error("validate --checksum-prefix sha256= accepted a sha256: checksum")
+	}
+}
+
+func TestPackageReproducible(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "app", "app\n")
+
+	run(t, packageCommand, "-o", "first", "app")
+
+	// Neither the time of packaging nor the modification time of the input
+	// may end up in the package.
+	later := time.Now().Add(time.Hour)
+
+	if err := os.Chtimes("app", later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, packageCommand, "-o", "second", "app")
+
+	first, err := os.ReadFile("first.package")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := os.ReadFile("second.package")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Error("packaging the same input twice produced different bytes")
+	}
+
+	// The gzip header stores its modification time in bytes 4 to 8.
+	if !bytes.Equal(first[4:8], []byte{0, 0, 0, 0}) {
+		t.Errorf("gzip header modification time is %x, want zero", first[4:8])
+	}
+}
+
+func TestInstallRejectsSpecialEntries(t *testing.T) {
+	for _, typeflag := range []byte{tar.TypeChar, tar.TypeBlock, tar.TypeFifo, tar.TypeSymlink} {
+		inTempDir(t)
+
+		special := &tar.Header{
+			Name:     entryName(checksum(nil), "", "dev"),
+			Mode:     0644,
+			Typeflag: typeflag,
+			Devmajor: 1,
+			Devminor: 3,
+		}
+
+		if typeflag == tar.TypeSymlink {
+			special.Linkname = "/etc/passwd"
+		}
+
+		writePackage(t, "p", []packagedFile{entryFile("x", "x\n", 0755), {special, nil}})
+
+		err := installCommand([]string{"p.package"})
+
+		if err == nil || !strings.Contains(err.reason, "unexpected tar entry type") {
+			t.Errorf("install of a %q entry returned %v, want it rejected", typeflag, err)
+		}
+
+		err = extractCommand([]string{"p.package", "out"})
+
+		if err == nil || !strings.Contains(err.reason, "unexpected tar entry type") {
+			t.Errorf("extract of a %q entry returned %v, want it rejected", typeflag, err)
+		}
+
+		// --allow-special skips the entry rather than writing it as an
+		// empty regular file.
+		run(t, installCommand, "--allow-special", "p.package")
+		assertFile(t, ".bin/x", "x\n")
+
+		if _, err := os.Lstat(".bin/dev"); !os.IsNotExist(err) {
+			t.Errorf("%q entry was extracted to .bin/dev", typeflag)
+		}
+	}
+}
+
+func TestMergeRoundTrip(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "app", "linux\n")
+	run(t, packageCommand, "--platform", "linux/amd64", "-o", "linux", "app")
+	writeFile(t, "app", "darwin\n")
+	run(t, packageCommand, "--platform", "darwin/arm64", "-o", "darwin", "app")
+
+	run(t, mergeCommand, "linux.package", "darwin.package", "-o", "all")
+	run(t, validateCommand, "all.package")
+
+	if n := len(readHeaders(t, "all.package")); n != 2 {
+		t.Fatalf("merged package holds %d entries, want 2", n)
+	}
+
+	run(t, extractCommand, "--platform", "linux/amd64", "all.package", "linux")
+	assertFile(t, "linux/app", "linux\n")
+
+	run(t, extractCommand, "--platform", "darwin/arm64", "all.package", "darwin")
+	assertFile(t, "darwin/app", "darwin\n")
+
+	// An untagged app would be installed over the app of both platforms.
+	run(t, packageCommand, "-o", "untagged", "app")
+
+	if err := mergeCommand([]string{"-o", "bad", "all.package", "untagged.package"}); err == nil || !strings.Contains(err.reason, "conflicts") {
+		t.Errorf("merging an untagged app into tagged ones returned %v, want a conflict", err)
+	}
+}
+
+func TestMergeDedup(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "shared", "shared\n")
+	run(t, packageCommand, "--platform", "linux/amd64", "-o", "linux", "shared")
+	run(t, packageCommand, "--platform", "darwin/arm64", "-o", "darwin", "shared")
+
+	run(t, mergeCommand, "--dedup", "-o", "all", "linux.package", "darwin.package")
+
+	headers := readHeaders(t, "all.package")
+
+	if len(headers) != 2 {
+		t.Fatalf("merged package holds %d entries, want 2", len(headers))
+	}
+
+	if headers[1].Typeflag != tar.TypeLink || headers[1].Linkname != headers[0].Name {
+		t.Fatalf("%s is not a link to %s", headers[1].Name, headers[0].Name)
+	}
+
+	// The darwin entry links to the linux one, which is not extracted on
+	// darwin.
+	for _, platform := range []string{"linux/amd64", "darwin/arm64"} {
+		dir := strings.ReplaceAll(platform, "/", "-")
+		run(t, extractCommand, "--platform", platform, "all.package", dir)
+		assertFile(t, dir+"/shared", "shared\n")
+	}
+}
+
+func TestChecksumSha256sumCompat(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "app", "app\n")
+
+	digest := strings.TrimPrefix(checksum([]byte("app\n")), "sha256:")
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--sha256sum-compat", "app"}, digest + "  app\n"},
+		{[]string{"--sha256sum-compat", "--binary", "app"}, digest + " *app\n"},
+	} {
+		out := run(t, checksumCommand, c.args...)
+
+		if out != c.want {
+			t.Errorf("checksum %v printed %q, want %q", c.args, out, c.want)
+			continue
+		}
+
+		if _, err := exec.LookPath("sha256sum"); err != nil {
+			continue
+		}
+
+		writeFile(t, "app.sha256", out)
+
+		if b, err := exec.Command("sha256sum", "-c", "app.sha256").CombinedOutput(); err != nil {
+			t.Errorf("sha256sum -c rejected the output of checksum %v: %s", c.args, b)
+		}
+	}
+}
+
+func TestPackageStdinEntryModeDefault(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want os.FileMode
+	}{
+		{nil, 0755},
+		{[]string{"--entry-mode-default", "0750"}, 0750},
+	} {
+		inTempDir(t)
+		withStdin(t, "app\n")
+
+		run(t, packageCommand, append(c.args, "--name", "app", "-o", "p", "-")...)
+
+		headers := readHeaders(t, "p.package")
+
+		if len(headers) != 1 || os.FileMode(headers[0].Mode).Perm() != c.want {
+			t.Fatalf("package %v recorded %v, want a single entry with mode %v", c.args, headers, c.want)
+		}
+
+		run(t, installCommand, "p.package")
+
+		stat, err := os.Stat(".bin/app")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Mode().Perm() != c.want {
+			t.Errorf("package %v installed .bin/app with mode %v, want %v", c.args, stat.Mode().Perm(), c.want)
+		}
+	}
+}
+
+func TestInstallSkipsIndex(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "a", "a\n")
+	writeFile(t, "b", "b\n")
+
+	run(t, packageCommand, "--write-index", "-o", "p", "a", "b")
+
+	if headers := readHeaders(t, "p.package"); headers[0].Name != metadataPrefix+"index" {
+		t.Fatalf("first entry is %s, want the index", headers[0].Name)
+	}
+
+	run(t, installCommand, "p.package")
+
+	if got := listDir(t, ".bin"); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("install wrote %v, want only the binaries a and b", got)
+	}
+
+	run(t, extractCommand, "p.package", "out")
+
+	if got := listDir(t, "out"); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("extract wrote %v, want only the binaries a and b", got)
+	}
+
+	run(t, extractCommand, "--keep-index", "p.package", "kept")
+
+	if got := listDir(t, "kept"); !slices.Equal(got, []string{"a", "b", "bin/index"}) {
+		t.Errorf("extract --keep-index wrote %v, want a, b and bin/index", got)
+	}
+
+	run(t, installCommand, "--keep-index", "p.package")
+
+	if got := listDir(t, ".bin"); !slices.Equal(got, []string{"a", "b", "bin/index"}) {
+		t.Errorf("install --keep-index wrote %v, want a, b and bin/index", got)
+	}
+}
+
+func TestPackageFailEmpty(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "entries", "")
+
+	err := packageCommand([]string{"--entries-from-file", "entries", "-o", "p"})
+
+	if err == nil || !strings.Contains(err.reason, "empty package") {
+		t.Errorf("packaging no binaries returned %v, want it refused", err)
+	}
+
+	if _, err := os.Stat("p.package"); !os.IsNotExist(err) {
+		t.Error("a refused empty package was written")
+	}
+
+	run(t, packageCommand, "--fail-empty-package=false", "--entries-from-file", "entries", "-o", "p")
+
+	if n := len(readHeaders(t, "p.package")); n != 0 {
+		t.Errorf("empty package holds %d entries, want none", n)
+	}
+
+	run(t, validateCommand, "p.package")
+}
+
+func TestPackageModeRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		mode      string
+		wantA     os.FileMode
+		wantB     os.FileMode
+		wantError bool
+	}{
+		{"source", 0750, 0640, false},
+		{"fixed:0755", 0755, 0755, false},
+		{"fixed:0600", 0600, 0600, false},
+		{"fixed:999", 0, 0, true},
+		{"executable", 0, 0, true},
+	} {
+		inTempDir(t)
+		writeFile(t, "a", "a\n")
+		writeFile(t, "b", "b\n")
+
+		for path, mode := range map[string]os.FileMode{"a": 0750, "b": 0640} {
+			if err := os.Chmod(path, mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if c.wantError {
+			if err := packageCommand([]string{"--mode", c.mode, "-o", "p", "a", "b"}); err == nil {
+				t.Errorf("package --mode %s succeeded, want an error", c.mode)
+			}
+			continue
+		}
+
+		run(t, packageCommand, "--mode", c.mode, "-o", "p", "a", "b")
+		run(t, installCommand, "p.package")
+
+		for path, want := range map[string]os.FileMode{"a": c.wantA, "b": c.wantB} {
+			stat, err := os.Stat(filepath.Join(".bin", path))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stat.Mode().Perm() != want {
+				t.Errorf("package --mode %s installed %s with mode %v, want %v", c.mode, path, stat.Mode().Perm(), want)
+			}
+		}
+	}
+}
+
+func TestPackageTarFormat(t *testing.T) {
+	for name, format := range tarFormats {
+		inTempDir(t)
+		writeFile(t, "app", "app\n")
+
+		run(t, packageCommand, "--tar-format", name, "-o", "p", "app")
+
+		// A PAX header without records is a plain ustar header, which is
+		// how the reader reports it.
+		accepted := format
+		if format == tar.FormatPAX {
+			accepted |= tar.FormatUSTAR
+		}
+
+		headers := readHeaders(t, "p.package")
+
+		if len(headers) != 1 || headers[0].Format&accepted == 0 {
+			t.Fatalf("package --tar-format %s wrote %d entries, want a single %v entry", name, len(headers), format)
+		}
+
+		want := checksum([]byte("app\n")) + ":app\n"
+
+		if out := run(t, inspectCommand, "p.package"); out != want {
+			t.Errorf("inspect of a %s package printed %q, want %q", name, out, want)
+		}
+	}
+
+	inTempDir(t)
+
+	// Entry names start with the checksum, so a long base name without a
+	// slash to split at does not fit the 100 bytes of a ustar name.
+	long := strings.Repeat("x", 60)
+	writeFile(t, long, "app\n")
+
+	if err := packageCommand([]string{"--tar-format", "ustar", "-o", "p", long}); err == nil {
+		t.Error("package --tar-format ustar accepted a name ustar cannot encode")
+	}
+
+	run(t, packageCommand, "--tar-format", "pax", "-o", "p", long)
+
+	if headers := readHeaders(t, "p.package"); headers[0].Format != tar.FormatPAX {
+		t.Errorf("long name was written as %v, want PAX", headers[0].Format)
+	}
+
+	if err := packageCommand([]string{"--tar-format", "v7", "-o", "p", "x"}); err == nil {
+		t.Error("package --tar-format v7 succeeded, want an unsupported format error")
+	}
+}
+
+func TestInstallPlatformDefault(t *testing.T) {
+	current := runtime.GOOS + "/" + runtime.GOARCH
+	other := "plan9/mips"
+
+	if current == other {
+		other = "plan9/arm"
+	}
+
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"app", "shared"}},
+		{[]string{"--platform-default", "any"}, []string{"app", "shared"}},
+		{[]string{"--platform-default", "none"}, []string{"app"}},
+	} {
+		inTempDir(t)
+		writePackage(t, "p", []packagedFile{
+			platformFile(current, "app", "current\n", 0755),
+			platformFile(other, "app", "other\n", 0755),
+			platformFile(other, "only-other", "other\n", 0755),
+			entryFile("shared", "shared\n", 0644),
+		})
+
+		run(t, installCommand, append(c.args, "p.package")...)
+
+		if got := listDir(t, ".bin"); !slices.Equal(got, c.want) {
+			t.Errorf("install %v wrote %v, want %v", c.args, got, c.want)
+		}
+
+		assertFile(t, ".bin/app", "current\n")
+	}
+
+	inTempDir(t)
+	writePackage(t, "p", []packagedFile{entryFile("shared", "shared\n", 0644)})
+
+	if err := installCommand([]string{"--platform-default", "some", "p.package"}); err == nil {
+		t.Error("install --platform-default some succeeded, want an error")
+	}
+}
+
+func BenchmarkValidateDirectory(b *testing.B) {
+	inTempDir(b)
+
+	if err := os.Mkdir("dist", 0755); err != nil {
+		b.Fatal(err)
+	}
+
+	content := strings.Repeat("x", 64<<10)
+
+	for i := 0; i < 256; i++ {
+		writeFile(b, filepath.Join("dist", fmt.Sprintf("file%03d", i)), content)
+	}
+
+	run(b, checksumCommand, "--recursive", "--manifest", "dist.manifest", "dist")
+
+	for _, jobs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrent-validate=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(256 * len(content)))
+
+			for i := 0; i < b.N; i++ {
+				run(b, validateCommand, "--concurrent-validate", strconv.Itoa(jobs), "dist", "dist.manifest")
+			}
+		})
+	}
+}
+
+func BenchmarkChecksumHashBufferSize(b *testing.B) {
+	inTempDir(b)
+
+	const size = 64 << 20
+	writeFile(b, "large", strings.Repeat("x", size))
+
+	// 0 is the default buffer of io.CopyBuffer.
+	for _, bufSize := range []int{0, minHashBuffer, 1 << 20, 8 << 20} {
+		name := "default"
+		if bufSize > 0 {
+			name = strconv.Itoa(bufSize)
+		}
+
+		b.Run("hash-buffer-size="+name, func(b *testing.B) {
+			b.SetBytes(size)
+
+			for i := 0; i < b.N; i++ {
+				if _, err := checksumFile("sha256", "large", bufSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPackageSeparateDeterministic(t *testing.T) {
+	inTempDir(t)
+
+	var paths []string
+	for i := 0; i < 8; i++ {
+		paths = append(paths, fmt.Sprintf("app%d", i))
+		writeFile(t, paths[i], strings.Repeat(paths[i], 1000))
+	}
+
+	run(t, packageCommand, append([]string{"--separate"}, paths...)...)
+
+	serial := map[string][]byte{}
+	for _, p := range paths {
+		b, err := os.ReadFile(p + ".package")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serial[p] = b
+	}
+
+	run(t, packageCommand, append([]string{"--separate", "--concurrent-package", "4"}, paths...)...)
+
+	for _, p := range paths {
+		b, err := os.ReadFile(p + ".package")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, serial[p]) {
+			t.Errorf("%s.package differs between serial and concurrent packaging", p)
+		}
+
+		run(t, validateCommand, p+".package")
+	}
+}
+
+func BenchmarkPackageSeparate(b *testing.B) {
+	inTempDir(b)
+
+	var paths []string
+	for i := 0; i < 32; i++ {
+		paths = append(paths, fmt.Sprintf("app%02d", i))
+		writeFile(b, paths[i], strings.Repeat(paths[i], 1<<19))
+	}
+
+	for _, jobs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrent-package=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(32 * 5 << 19))
+
+			for i := 0; i < b.N; i++ {
+				run(b, packageCommand, append([]string{"--separate", "--concurrent-package", strconv.Itoa(jobs)}, paths...)...)
+			}
+		})
+	}
+}
+
+func TestInstallDependencySha256Fallback(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "lib", "lib\n")
+	run(t, packageCommand, "-o", "lib", "lib")
+
+	b, err := os.ReadFile("lib.checksum")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := strings.TrimSpace(string(b))
+
+	// Ship the dependency with only a sha256sum style .sha256 file.
+	writeFile(t, "lib.sha256", strings.TrimPrefix(sum, "sha256:")+"  lib.package\n")
+
+	if err := os.Remove("lib.checksum"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, "app", "app\n")
+	run(t, packageCommand, "--dep", "lib@"+sum, "-o", "app", "app")
+	run(t, installCommand, "--follow-package-deps", "app.package")
+
+	assertFile(t, ".bin/app", "app\n")
+	assertFile(t, ".bin/lib", "lib\n")
+}
+
+func TestChecksumManifestSha256sumCompat(t *testing.T) {
+	inTempDir(t)
+
+	if err := os.MkdirAll("dist/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, "dist/a", "a\n")
+	writeFile(t, "dist/sub/b", "b\n")
+
+	run(t, checksumCommand, "--recursive", "--manifest", "dist.sha256", "dist")
+
+	want := strings.TrimPrefix(checksum([]byte("a\n")), "sha256:") + "  a\n" +
+		strings.TrimPrefix(checksum([]byte("b\n")), "sha256:") + "  sub/b\n"
+
+	assertFile(t, "dist.sha256", want)
+	run(t, validateCommand, "dist", "dist.sha256")
+
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		return
+	}
+
+	cmd := exec.Command("sha256sum", "-c", "../dist.sha256")
+	cmd.Dir = "dist"
+
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("sha256sum -c rejected the manifest: %s", b)
+	}
+}
+
+func TestPackageSkipExisting(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		inTempDir(t)
+		writeFile(t, "app", strings.Repeat("app\n", 1024))
+
+		args := []string{"--skip-existing", "-o", "p", "app"}
+		if split {
+			args = append([]string{"--split-size", "1K"}, args...)
+		}
+
+		if out := run(t, packageCommand, args...); strings.Contains(out, "up to date") {
+			t.Fatalf("package %v skipped a package that did not exist", args)
+		}
+
+		if out := run(t, packageCommand, args...); !strings.Contains(out, "up to date") {
+			t.Errorf("package %v rewrote an up to date package", args)
+		}
+
+		writeFile(t, "app", "changed\n")
+
+		if out := run(t, packageCommand, args...); strings.Contains(out, "up to date") {
+			t.Errorf("package %v skipped a package whose input changed", args)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// canonicalJSON encodes v as JSON with the keys of every object sorted, so
+// tools hashing or diffing the output see the same bytes for equal values.
+// With indent the output is indented by two spaces, otherwise it is compact.
+func canonicalJSON(v any, indent bool) ([]byte, error) {
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding into generic values turns objects into maps, which encode
+	// with sorted keys. Numbers are kept as written.
+	var generic any
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	if err := d.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	if indent {
+		return json.MarshalIndent(generic, "", "  ")
+	}
+
+	return json.Marshal(generic)
+}
@@ -0,0 +1,37 @@
+package main
+
+import "os"
+
+// colorMode is set by the global --color flag to auto, always or never.
+var colorMode = "auto"
+
+// useColor reports whether human readable output on stdout is colored. In
+// auto mode it is when stdout is a terminal and NO_COLOR is not set.
+func useColor() bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	stat, err := os.Stdout.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(code, s string) string {
+	if !useColor() {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+func green(s string) string { return colorize("32", s) }
+
+func red(s string) string { return colorize("31", s) }
+
+func bold(s string) string { return colorize("1", s) }
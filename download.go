@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// defaultMaxDownload is the largest download accepted unless a command is
+// told otherwise, to stop a misconfigured url from pulling a huge file.
+const defaultMaxDownload = 1 << 30
+
+// download fetches url and returns its body. A body shorter than the
+// Content-Length of the response is reported as truncated. Bodies larger
+// than max bytes are rejected, from the Content-Length if the server sends
+// one and otherwise as soon as the read passes max. A max of 0 accepts any
+// size.
+func download(url string, max int64) ([]byte, error) {
+	resp, err := http.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	if max > 0 && resp.ContentLength > max {
+		return nil, fmt.Errorf("%s: download of %s exceeds the limit of %s", url, formatBytes(resp.ContentLength), formatBytes(max))
+	}
+
+	var body io.Reader = resp.Body
+	if max > 0 {
+		body = io.LimitReader(resp.Body, max+1)
+	}
+
+	b, err := io.ReadAll(body)
+
+	if max > 0 && int64(len(b)) > max {
+		return nil, fmt.Errorf("%s: download exceeds the limit of %s", url, formatBytes(max))
+	}
+
+	// Fail on a dropped connection with a clear message before the caller
+	// spends time hashing a partial download.
+	if resp.ContentLength >= 0 && int64(len(b)) != resp.ContentLength {
+		return nil, fmt.Errorf("%s: truncated download: got %d, expected %d", url, len(b), resp.ContentLength)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
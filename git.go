@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// changedSince returns the paths of the files changed since the git commit
+// ref, relative to the working directory.
+func changedSince(ref string) ([]string, error) {
+	if err := exec.Command("git", "rev-parse", "--is-inside-work-tree").Run(); err != nil {
+		return nil, errors.New("--since-commit requires running inside a git repository")
+	}
+
+	// Resolving ref to a commit first keeps a ref such as --output=file
+	// from being read as an option by git diff.
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	cmd.Stderr = &stderr
+
+	commit, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a commit", ref)
+	}
+
+	cmd = exec.Command("git", "diff", "--name-only", "--relative", "-z", strings.TrimSpace(string(commit)), "--")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+		return nil, fmt.Errorf("git diff %s: %s", ref, msg)
+	}
+
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	// Paths are separated by NUL bytes, since they may contain spaces.
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00"), nil
+}
+
+// mapSources maps changed source files to the binaries built from them. Each
+// source map is a <source>=<binary> pair. The segments of source are matched
+// with path.Match against the leading segments of a changed file, and a *
+// in binary is replaced by the segment matched by the first * of source.
+// For example cmd/*=build/* maps cmd/app/main.go to build/app. Every binary
+// is returned once, in the order it was first mapped.
+func mapSources(changed, maps []string) ([]string, error) {
+	var binaries []string
+	seen := map[string]bool{}
+
+	for _, m := range maps {
+		source, binary, ok := strings.Cut(m, "=")
+
+		if !ok || source == "" || binary == "" {
+			return nil, fmt.Errorf("%s is not a valid source map, expected <source>=<binary>", m)
+		}
+
+		pattern := strings.Split(strings.Trim(source, "/"), "/")
+
+		for _, file := range changed {
+			segments := strings.Split(file, "/")
+
+			if len(segments) < len(pattern) {
+				continue
+			}
+
+			capture, matched := "", true
+
+			for i, p := range pattern {
+				ok, err := path.Match(p, segments[i])
+
+				if err != nil {
+					return nil, fmt.Errorf("%s is not a valid source map: %s", m, err)
+				}
+
+				if !ok {
+					matched = false
+					break
+				}
+
+				if p == "*" && capture == "" {
+					capture = segments[i]
+				}
+			}
+
+			if !matched {
+				continue
+			}
+
+			b := strings.Replace(binary, "*", capture, 1)
+
+			if !seen[b] {
+				seen[b] = true
+				binaries = append(binaries, b)
+			}
+		}
+	}
+
+	return binaries, nil
+}
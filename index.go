@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// packagedFile is a file entry of a package being written. data is nil for
+// entries linking to the content of another entry.
+type packagedFile struct {
+	header *tar.Header
+	data   []byte
+}
+
+// packageIndex is the table of contents package --write-index stores as the
+// first entry of a package, so its contents can be listed without reading
+// the whole package. The format is documented in the README.
+type packageIndex struct {
+	Entries []indexEntry `json:"entries"`
+}
+
+type indexEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Digest   string `json:"digest"`
+	Platform string `json:"platform,omitempty"`
+	Size     int64  `json:"size"`
+	Mode     string `json:"mode"`
+	Link     string `json:"link,omitempty"`
+}
+
+func newPackageIndex(files []packagedFile) packageIndex {
+	index := packageIndex{Entries: []indexEntry{}}
+	sizes := map[string]int64{}
+
+	for _, f := range files {
+		// Names were built by entryName, so they always parse.
+		e, _ := parseEntry(f.header.Name)
+
+		size := f.header.Size
+		if f.header.Typeflag == tar.TypeLink {
+			size = sizes[f.header.Linkname]
+		}
+		sizes[f.header.Name] = size
+
+		index.Entries = append(index.Entries, indexEntry{
+			Name:     f.header.Name,
+			Path:     e.path,
+			Digest:   e.digest,
+			Platform: e.platform(),
+			Size:     size,
+			Mode:     fmt.Sprintf("%04o", f.header.Mode),
+			Link:     f.header.Linkname,
+		})
+	}
+
+	return index
+}
@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// retryLocked calls write until it succeeds, fails with an error other than
+// the target being locked, or was retried retries times. The delay between
+// attempts doubles every time, starting at lockedBackoff.
+func retryLocked(retries int, write func() error) error {
+	delay := lockedBackoff
+
+	for i := 0; ; i++ {
+		err := write()
+
+		if err == nil || i >= retries || !isLocked(err) {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+const lockedBackoff = 100 * time.Millisecond
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// isLocked reports whether err was caused by the file being in use. Only
+// Windows refuses to overwrite files in use.
+func isLocked(err error) bool {
+	return false
+}
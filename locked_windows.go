@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for files opened by another process without sharing.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLocked reports whether err was caused by the file being in use, such as
+// a binary that is currently running.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// lockfile pins installed packages, keyed by package name, to the exact
+// package they were installed from.
+type lockfile map[string]lockEntry
+
+type lockEntry struct {
+	Digest  string `json:"digest"`
+	Version string `json:"version,omitempty"`
+}
+
+// readLockfile reads the lockfile at path. A missing file is an empty
+// lockfile.
+func readLockfile(path string) (lockfile, error) {
+	lock := lockfile{}
+	b, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return nil, err
+	}
+
+	// A lockfile holding null unmarshals to a nil map.
+	if lock == nil {
+		lock = lockfile{}
+	}
+
+	return lock, nil
+}
+
+func writeLockfile(path string, lock lockfile) error {
+	b, err := json.MarshalIndent(lock, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(b, '\n'), 0644)
+}
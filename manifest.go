@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestLine is a file listed in a checksum manifest.
+type manifestLine struct {
+	path      string
+	algorithm string
+	digest    string
+}
+
+// readManifest reads a checksum manifest with one <checksum>  <path> line per
+// file, where the checksum is in any form accepted by parseChecksum. This
+// includes the output of sha256sum, whose binary marker is ignored.
+func readManifest(path string) ([]manifestLine, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []manifestLine
+
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " \t"), "*")
+
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: malformed line, expected <checksum>  <path>", path, i+1)
+		}
+
+		algorithm, digest, err := parseChecksum(sum)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+
+		lines = append(lines, manifestLine{name, algorithm, digest})
+	}
+
+	return lines, nil
+}
+
+// writeManifest writes a manifest of every file below dir to manifest,
+// hashing them with algorithm, and returns the checksum of the manifest
+// itself. Lines are written in the <hex>  <path> format of sha256sum and
+// sha512sum, so sha256sum -c can check the files from within dir. The manifest and its checksum file are left out when they are
+// inside dir.
+func writeManifest(dir, manifest, algorithm string, bufSize int) (string, error) {
+	skip := map[string]bool{}
+	for _, p := range []string{manifest, manifest + ".checksum"} {
+		abs, err := filepath.Abs(p)
+
+		if err != nil {
+			return "", err
+		}
+
+		skip[abs] = true
+	}
+
+	var b strings.Builder
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		abs, err := filepath.Abs(p)
+
+		if err != nil || skip[abs] {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+
+		if err != nil {
+			return err
+		}
+
+		sum, err := checksumFile(algorithm, p, bufSize)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&b, "%s  %s\n", strings.TrimPrefix(sum, algorithm+":"), filepath.ToSlash(rel))
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(manifest, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+
+	return checksumWith(algorithm, []byte(b.String()))
+}
+
+// manifestResult is the outcome of validating one file of a manifest, as
+// written by validate --json-report.
+type manifestResult struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Computed string `json:"computed,omitempty"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// validateManifest validates the files in dir against the checksums listed
+// in manifest, hashing up to jobs files at a time. The result of every file
+// is printed in manifest order and, if report is set, written to it as JSON.
+func validateManifest(dir, manifest string, jobs int, report string) *cmderr {
+	lines, err := readManifest(manifest)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	results := make([]manifestResult, len(lines))
+
+	parallel(jobs, len(lines), func(i int) {
+		expected := fmt.Sprintf("%s:%s", lines[i].algorithm, lines[i].digest)
+		sum, err := checkFile(filepath.Join(dir, lines[i].path), lines[i].algorithm, lines[i].digest)
+
+		results[i] = manifestResult{Path: lines[i].path, Expected: expected, Computed: sum, OK: err == nil}
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	})
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+			fmt.Printf("%s: %s (%s)\n", r.Path, red("FAILED"), r.Error)
+			continue
+		}
+		fmt.Printf("%s: %s\n", r.Path, green("OK"))
+	}
+
+	if report != "" {
+		b, err := canonicalJSON(results, true)
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if err := writeFileAtomic(report, append(b, '\n'), 0644); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if failed > 0 {
+		return &cmderr{1, fmt.Sprintf("%d of %d files failed validation", failed, len(lines))}
+	}
+
+	return nil
+}
+
+// checkFile returns the checksum of the file at path with the given
+// algorithm and an error unless it is digest.
+func checkFile(path, algorithm, digest string) (string, error) {
+	sum, err := checksumFile(algorithm, path, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	if sum != fmt.Sprintf("%s:%s", algorithm, digest) {
+		return sum, fmt.Errorf("invalid checksum")
+	}
+
+	return sum, nil
+}
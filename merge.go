@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func mergeCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	output := flags.String("o", "", "write the merged package to `path`")
+	dedup := flags.Bool("dedup", false, "store content shared by the packages only once")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if len(args) < 2 {
+		return &cmderr{1, "missing paths to at least two packages to merge as arguments"}
+	}
+
+	if *output == "" {
+		return &cmderr{1, "missing -o with the path of the merged package"}
+	}
+
+	var files []packagedFile
+	metadata := map[string][]byte{}
+	var keys []string
+
+	// merged maps the path and platform of every merged entry to the name
+	// of the entry, to find entries that would install over each other.
+	merged := map[string]string{}
+
+	// first maps the checksum of each distinct content to the name of the
+	// entry holding it, for --dedup.
+	first := map[string]string{}
+
+	for _, pkg := range args {
+		pr, cerr := openPackage(pkg, false, nil)
+		if cerr != nil {
+			return cerr
+		}
+
+		err := func() *cmderr {
+			defer pr.Close()
+
+			for {
+				hdr, err := pr.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return &cmderr{1, fmt.Sprintf("%s: %s", pkg, err)}
+				}
+
+				b, err := io.ReadAll(pr)
+
+				if err != nil {
+					return &cmderr{1, fmt.Sprintf("%s: %s", pkg, err)}
+				}
+
+				if isMetadata(hdr.Name) {
+					key := strings.TrimPrefix(hdr.Name, metadataPrefix)
+
+					// The index only describes the input it came from.
+					if key == "index" {
+						continue
+					}
+
+					if existing, ok := metadata[key]; ok {
+						if !bytes.Equal(existing, b) {
+							return &cmderr{1, fmt.Sprintf("%s: %s differs from the packages before it", pkg, hdr.Name)}
+						}
+						continue
+					}
+
+					metadata[key] = b
+					keys = append(keys, key)
+					continue
+				}
+
+				e, err := parseEntry(hdr.Name)
+
+				if err != nil {
+					return &cmderr{1, fmt.Sprintf("%s: %s", pkg, err)}
+				}
+
+				if cerr := checkMergeConflict(merged, e, hdr.Name); cerr != nil {
+					return &cmderr{1, fmt.Sprintf("%s: %s", pkg, cerr.reason)}
+				}
+
+				if _, ok := merged[e.platform()+":"+e.path]; ok {
+					continue
+				}
+
+				merged[e.platform()+":"+e.path] = hdr.Name
+
+				if hdr.Typeflag != tar.TypeReg {
+					files = append(files, packagedFile{hdr, b})
+					continue
+				}
+
+				if name, ok := first[e.digest]; ok && *dedup {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = name
+					hdr.Size = 0
+					files = append(files, packagedFile{hdr, nil})
+					continue
+				}
+
+				first[e.digest] = hdr.Name
+				files = append(files, packagedFile{hdr, b})
+			}
+		}()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	for _, key := range keys {
+		if err := writeMetadata(tw, tar.FormatUnknown, key, metadata[key]); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	for _, f := range files {
+		if err := tw.WriteHeader(f.header); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if _, err := tw.Write(f.data); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if err := gw.Close(); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	b := buf.Bytes()
+	name := strings.TrimSuffix(*output, ".package")
+
+	if err := writeFileAtomic(name+".package", b, 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if err := writeFileAtomic(name+".checksum", []byte(checksum(b)+"\n"), 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	return nil
+}
+
+// checkMergeConflict fails if the entry e named name would be installed over
+// an entry already merged. Entries for the same path only coexist if they
+// are tagged with different platforms, or are the very same entry.
+func checkMergeConflict(merged map[string]string, e entry, name string) *cmderr {
+	for key, other := range merged {
+		platform, path, _ := strings.Cut(key, ":")
+
+		if path != e.path || other == name {
+			continue
+		}
+
+		if platform == "" || e.platform() == "" || platform == e.platform() {
+			return &cmderr{1, fmt.Sprintf("%s conflicts with %s", name, other)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Media types of the OCI image spec used by writeOCI.
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ociChecksumAnnotation holds the checksums of the packaged binaries, one
+// "<checksum>  <path>" line each.
+const ociChecksumAnnotation = "io.github.quartercastle.bin.checksums"
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociConfig struct {
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	RootFS       ociRootFS `json:"rootfs"`
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// writeOCI writes an OCI image layout to dir holding the inputs as a single
+// layer, with their checksums recorded as an annotation of the manifest.
+// Inputs without permission bits get defaultMode. The image config is
+// labelled with platform, the os/arch pair the binaries were built for.
+func writeOCI(dir string, inputs []input, defaultMode os.FileMode, platform string) error {
+	goos, goarch, _ := strings.Cut(platform, "/")
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	var sums []string
+
+	for _, in := range inputs {
+		b, mode, err := in.read()
+
+		if err != nil {
+			return err
+		}
+
+		if mode.Perm() == 0 {
+			mode |= defaultMode
+		}
+
+		header := &tar.Header{
+			Name: in.name,
+			Mode: int64(mode.Perm()),
+			Size: int64(len(b)),
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+
+		sums = append(sums, fmt.Sprintf("%s  %s", checksum(b), in.name))
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+
+	if _, err := gw.Write(layer.Bytes()); err != nil {
+		return err
+	}
+
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	blobs := filepath.Join(dir, "blobs", "sha256")
+
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return err
+	}
+
+	writeBlob := func(mediaType string, b []byte) (ociDescriptor, error) {
+		digest := fmt.Sprintf("%x", sha256.Sum256(b))
+
+		if err := os.WriteFile(filepath.Join(blobs, digest), b, 0644); err != nil {
+			return ociDescriptor{}, err
+		}
+
+		return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(b))}, nil
+	}
+
+	config, err := json.Marshal(ociConfig{
+		Architecture: goarch,
+		OS:           goos,
+		RootFS:       ociRootFS{Type: "layers", DiffIDs: []string{checksum(layer.Bytes())}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	configDesc, err := writeBlob(ociConfigType, config)
+
+	if err != nil {
+		return err
+	}
+
+	layerDesc, err := writeBlob(ociLayerType, compressed.Bytes())
+
+	if err != nil {
+		return err
+	}
+
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		Config:        configDesc,
+		Layers:        []ociDescriptor{layerDesc},
+		Annotations:   map[string]string{ociChecksumAnnotation: strings.Join(sums, "\n")},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	manifestDesc, err := writeBlob(ociManifestType, manifest)
+
+	if err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{manifestDesc}})
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+}
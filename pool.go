@@ -0,0 +1,61 @@
+package main
+
+import "sync"
+
+// workerSlots caps the worker goroutines of all parallel calls together. It
+// is sized by the global --max-concurrency-global flag.
+var workerSlots = make(chan struct{})
+
+// setMaxConcurrency allows up to n workers to run at a time across all
+// parallel calls.
+func setMaxConcurrency(n int) {
+	// The first worker of every call runs on behalf of its caller and
+	// takes no slot, so a call always makes progress even when nested in
+	// another call that holds every slot.
+	workerSlots = make(chan struct{}, n-1)
+}
+
+// takeSlot takes a slot of the global cap if one is free.
+func takeSlot() bool {
+	select {
+	case workerSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// parallel calls fn for every index below n, running up to jobs calls at a
+// time, and returns once all of them returned. Workers beyond the first are
+// only started while the global cap has room, so jobs is an upper bound.
+func parallel(jobs, n int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < jobs && w < n; w++ {
+		if w > 0 && !takeSlot() {
+			break
+		}
+
+		wg.Add(1)
+		go func(slot bool) {
+			defer wg.Done()
+			if slot {
+				defer func() { <-workerSlots }()
+			}
+			for i := range work {
+				fn(i)
+			}
+		}(w > 0)
+	}
+
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
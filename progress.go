@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progress wraps a reader and reports how much of it has been read. When the
+// total size is known it shows a percentage together with the throughput and
+// the estimated time remaining, otherwise only the amount read so far.
+type progress struct {
+	r     io.Reader
+	w     io.Writer
+	total int64
+	read  int64
+
+	// rate is a moving average of the throughput in bytes per second,
+	// sampled every time the progress is reported.
+	rate     float64
+	last     time.Time
+	lastRead int64
+
+	// log writes a line per report instead of redrawing a single line,
+	// for writing progress to a file.
+	log bool
+}
+
+const (
+	progressInterval    = 200 * time.Millisecond
+	progressLogInterval = 5 * time.Second
+)
+
+// newProgress returns r wrapped in a progress reporting to stderr, or r
+// itself if stderr is not a terminal. total is zero if the size is unknown.
+func newProgress(r io.Reader, total int64) io.Reader {
+	stat, err := os.Stderr.Stat()
+
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return r
+	}
+
+	return &progress{r: r, w: os.Stderr, total: total, last: time.Now()}
+}
+
+// newProgressLog returns r wrapped in a progress writing a line to w every
+// progressLogInterval, regardless of whether w is a terminal.
+func newProgressLog(r io.Reader, total int64, w io.Writer) io.Reader {
+	return &progress{r: r, w: w, total: total, last: time.Now(), log: true}
+}
+
+func (p *progress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	interval := progressInterval
+	if p.log {
+		interval = progressLogInterval
+	}
+
+	now := time.Now()
+	if now.Sub(p.last) >= interval || err == io.EOF {
+		p.sample(now)
+		p.report()
+	}
+
+	if err == io.EOF && !p.log {
+		fmt.Fprintln(p.w)
+	}
+
+	return n, err
+}
+
+func (p *progress) sample(now time.Time) {
+	elapsed := now.Sub(p.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	rate := float64(p.read-p.lastRead) / elapsed
+	if p.rate == 0 {
+		p.rate = rate
+	} else {
+		p.rate = 0.3*rate + 0.7*p.rate
+	}
+
+	p.last = now
+	p.lastRead = p.read
+}
+
+func (p *progress) report() {
+	if p.log {
+		fmt.Fprintln(p.w, strings.TrimSpace(p.status()))
+		return
+	}
+
+	fmt.Fprintf(p.w, "\r%s", p.status())
+}
+
+func (p *progress) status() string {
+	if p.total <= 0 {
+		return formatBytes(p.read)
+	}
+
+	percent := p.read * 100 / p.total
+	if p.rate <= 0 {
+		return fmt.Sprintf("%d%%", percent)
+	}
+
+	eta := time.Duration(float64(p.total-p.read) / p.rate * float64(time.Second))
+	return fmt.Sprintf("%d%% %s/s ETA %s   ", percent, formatBytes(int64(p.rate)), formatETA(eta))
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+func formatETA(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scrubCacheName is the file in the scrubbed directory that records the
+// packages found healthy by validate --scrub --only-changed.
+const scrubCacheName = ".bin-scrub-cache"
+
+// scrubStamp identifies the version of a package and its checksum file that
+// was found healthy, by their sizes and modification times.
+type scrubStamp struct {
+	Size         int64 `json:"size"`
+	ModTime      int64 `json:"mtime"`
+	ChecksumSize int64 `json:"checksum_size"`
+	ChecksumTime int64 `json:"checksum_mtime"`
+	Deep         bool  `json:"deep,omitempty"`
+}
+
+// stampPackage returns the stamp of the package at pkg as it is now.
+func stampPackage(pkg string, deep bool) (scrubStamp, error) {
+	sum, cerr := findChecksum(packageBase(pkg))
+	if cerr != nil {
+		return scrubStamp{}, errors.New(cerr.reason)
+	}
+
+	ps, err := os.Stat(pkg)
+
+	if err != nil {
+		return scrubStamp{}, err
+	}
+
+	cs, err := os.Stat(sum)
+
+	if err != nil {
+		return scrubStamp{}, err
+	}
+
+	return scrubStamp{ps.Size(), ps.ModTime().UnixNano(), cs.Size(), cs.ModTime().UnixNano(), deep}, nil
+}
+
+// covers reports whether a package found healthy at stamp s is still known
+// to be healthy at stamp now. A deep scrub covers a shallow one.
+func (s scrubStamp) covers(now scrubStamp) bool {
+	if now.Deep && !s.Deep {
+		return false
+	}
+
+	s.Deep, now.Deep = false, false
+	return s == now
+}
+
+func readScrubCache(path string) (map[string]scrubStamp, error) {
+	cache := map[string]scrubStamp{}
+
+	b, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return cache, nil
+}
+
+// scrub validates every package below dir against its checksum file,
+// validating up to jobs packages at a time. With deep, every package is also
+// fully decompressed and each entry checked against its own checksum, which
+// catches corruption that happened before the package checksum was taken.
+// With fullScan, every mismatching entry is reported rather than the first.
+//
+// With onlyChanged, packages that are unchanged since a previous run found
+// them healthy are assumed to still be valid and skipped, unless full is set.
+func scrub(dir string, deep, fullScan bool, jobs int, onlyChanged, full bool) *cmderr {
+	var pkgs []string
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(p, ".package") {
+			pkgs = append(pkgs, p)
+		}
+		return err
+	})
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	cachePath := filepath.Join(dir, scrubCacheName)
+	cache := map[string]scrubStamp{}
+
+	if onlyChanged && !full {
+		if cache, err = readScrubCache(cachePath); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	errs := make([]*cmderr, len(pkgs))
+	stamps := make([]scrubStamp, len(pkgs))
+	skipped := make([]bool, len(pkgs))
+
+	parallel(jobs, len(pkgs), func(i int) {
+		if onlyChanged {
+			// A package without a stamp is validated, which reports why.
+			stamps[i], _ = stampPackage(pkgs[i], deep)
+
+			if cached, ok := cache[pkgs[i]]; ok && cached.covers(stamps[i]) {
+				skipped[i] = true
+				return
+			}
+		}
+
+		if errs[i] = validateCommand([]string{pkgs[i]}); errs[i] == nil && deep {
+			errs[i] = verifyEntries(pkgs[i], fullScan)
+		}
+	})
+
+	corrupt, assumed := 0, 0
+	healthy := map[string]scrubStamp{}
+
+	for i, pkg := range pkgs {
+		if errs[i] != nil {
+			corrupt++
+			fmt.Printf("%s: %s (%s)\n", pkg, red("CORRUPT"), errs[i].reason)
+			continue
+		}
+
+		if skipped[i] {
+			assumed++
+			healthy[pkg] = cache[pkg]
+			fmt.Printf("%s: assumed-valid\n", pkg)
+			continue
+		}
+
+		healthy[pkg] = stamps[i]
+		fmt.Printf("%s: %s\n", pkg, green("healthy"))
+	}
+
+	if onlyChanged {
+		fmt.Printf("%d validated, %d assumed-valid, %d corrupt\n", len(pkgs)-assumed, assumed, corrupt)
+
+		b, err := json.MarshalIndent(healthy, "", "  ")
+
+		if err != nil {
+			return &cmderr{1, err.Error()}
+		}
+
+		if err := writeFileAtomic(cachePath, b, 0644); err != nil {
+			return &cmderr{1, err.Error()}
+		}
+	} else {
+		fmt.Printf("%d healthy, %d corrupt\n", len(pkgs)-corrupt, corrupt)
+	}
+
+	if corrupt > 0 {
+		return &cmderr{1, fmt.Sprintf("%d of %d packages are corrupt", corrupt, len(pkgs))}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// setChecksum returns a digest over the checksums of every package below
+// dir, so a whole release can be pinned with a single value. The checksums
+// are sorted before hashing, which keeps the digest independent of the
+// order the packages are found in.
+func setChecksum(dir string) (string, error) {
+	var sums []string
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".package") {
+			return err
+		}
+
+		path, cerr := findChecksum(packageBase(p))
+		if cerr != nil {
+			return fmt.Errorf("%s: %s", p, cerr.reason)
+		}
+
+		b, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		algorithm, digest, err := parseChecksum(string(b))
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		sums = append(sums, algorithm+":"+strings.ToLower(digest))
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(sums) == 0 {
+		return "", fmt.Errorf("no packages found in %s", dir)
+	}
+
+	sort.Strings(sums)
+
+	var b strings.Builder
+	for _, sum := range sums {
+		b.WriteString(sum + "\n")
+	}
+
+	return checksum([]byte(b.String())), nil
+}
+
+// validateSet checks the digest of the packages below dir against expected,
+// which is either the digest itself or the path of a file holding it.
+func validateSet(dir, expected string) *cmderr {
+	if b, err := os.ReadFile(expected); err == nil {
+		expected = string(b)
+	}
+
+	sum, err := setChecksum(dir)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	if sum != strings.TrimSpace(expected) {
+		return &cmderr{1, fmt.Sprintf("invalid checksum for package set %s", dir)}
+	}
+
+	return nil
+}
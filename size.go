@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeFlag is a flag holding a byte size such as 512, 64K or 1MiB. Units are
+// binary, so both 1M and 1MB are 1<<20 bytes.
+type sizeFlag int64
+
+var sizeUnits = []struct {
+	suffixes []string
+	scale    int64
+}{
+	{[]string{"GiB", "GB", "G"}, 1 << 30},
+	{[]string{"MiB", "MB", "M"}, 1 << 20},
+	{[]string{"KiB", "KB", "K"}, 1 << 10},
+	{[]string{"B"}, 1},
+}
+
+func parseSize(s string) (int64, error) {
+	number, scale := s, int64(1)
+
+unit:
+	for _, u := range sizeUnits {
+		for _, suffix := range u.suffixes {
+			if n, ok := strings.CutSuffix(s, suffix); ok {
+				number, scale = n, u.scale
+				break unit
+			}
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s is not a valid size", s)
+	}
+
+	return n * scale, nil
+}
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	*f = sizeFlag(n)
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitSuffix is appended to the package path for the manifest of a package
+// split into parts by package --split-size.
+const splitSuffix = ".manifest"
+
+// splitManifest lists the parts a package was split into, in order. Part
+// paths are relative to the directory of the manifest.
+type splitManifest struct {
+	Size  int64       `json:"size"`
+	Parts []splitPart `json:"parts"`
+}
+
+type splitPart struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"`
+}
+
+// writeSplit writes the package b as pkg.part0, pkg.part1, ... of at most
+// size bytes each and a manifest of them at pkg.manifest, which it returns.
+func writeSplit(pkg string, b []byte, size int64, write func(string, []byte, fs.FileMode) error) (string, error) {
+	manifest := splitManifest{Size: int64(len(b))}
+
+	for i := 0; len(b) > 0; i++ {
+		n := min(int64(len(b)), size)
+		part := fmt.Sprintf("%s.part%d", pkg, i)
+
+		if err := write(part, b[:n], 0644); err != nil {
+			return "", err
+		}
+
+		manifest.Parts = append(manifest.Parts, splitPart{filepath.Base(part), n, checksum(b[:n])})
+		b = b[n:]
+	}
+
+	mb, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return "", err
+	}
+
+	return pkg + splitSuffix, write(pkg+splitSuffix, append(mb, '\n'), 0644)
+}
+
+func readSplitManifest(path string) (splitManifest, error) {
+	var manifest splitManifest
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return manifest, fmt.Errorf("%s: malformed manifest: %s", path, err)
+	}
+
+	return manifest, nil
+}
+
+// partsReader reads the parts of a split package one after another.
+type partsReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *partsReader) Close() error {
+	var err error
+
+	for _, f := range r.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// openPackageFile opens the package at pkg and returns it with its size. If
+// pkg is the manifest of a split package, its parts are read in order as if
+// they were a single file.
+func openPackageFile(pkg string) (io.ReadCloser, int64, error) {
+	if !strings.HasSuffix(pkg, splitSuffix) {
+		file, err := os.Open(pkg)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		stat, err := file.Stat()
+
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+
+		return file, stat.Size(), nil
+	}
+
+	manifest, err := readSplitManifest(pkg)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &partsReader{}
+	var readers []io.Reader
+
+	for _, part := range manifest.Parts {
+		file, err := os.Open(filepath.Join(filepath.Dir(pkg), part.Path))
+
+		if err != nil {
+			r.Close()
+			return nil, 0, err
+		}
+
+		r.files = append(r.files, file)
+		readers = append(readers, file)
+	}
+
+	r.Reader = io.MultiReader(readers...)
+	return r, manifest.Size, nil
+}
+
+// packageBase returns the path of the package at pkg without the .package
+// suffix and, for split packages, the manifest suffix.
+func packageBase(pkg string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(pkg, splitSuffix), ".package")
+}
+
+func reassembleCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("reassemble", flag.ContinueOnError)
+	output := flags.String("o", "", "write the package to `path` instead of next to the manifest")
+
+	args, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if len(args) < 1 {
+		return &cmderr{1, "missing path to manifest as first argument"}
+	}
+
+	manifest, err := readSplitManifest(args[0])
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	var b []byte
+
+	for i, part := range manifest.Parts {
+		pb, err := os.ReadFile(filepath.Join(filepath.Dir(args[0]), part.Path))
+
+		if err != nil {
+			return &cmderr{1, fmt.Sprintf("part %d: %s", i, err)}
+		}
+
+		if int64(len(pb)) != part.Size {
+			return &cmderr{1, fmt.Sprintf("part %d: %s is %d bytes, expected %d", i, part.Path, len(pb), part.Size)}
+		}
+
+		if checksum(pb) != part.Digest {
+			return &cmderr{1, fmt.Sprintf("part %d: invalid checksum for %s", i, part.Path)}
+		}
+
+		b = append(b, pb...)
+	}
+
+	if int64(len(b)) != manifest.Size {
+		return &cmderr{1, fmt.Sprintf("parts add up to %d bytes, expected %d", len(b), manifest.Size)}
+	}
+
+	pkg := *output
+	if pkg == "" {
+		pkg = strings.TrimSuffix(args[0], splitSuffix)
+	}
+
+	if err := writeFileAtomic(pkg, b, 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	sum := fmt.Sprintf("%s.checksum", packageBase(pkg))
+
+	if err := writeFileAtomic(sum, []byte(fmt.Sprintf("%s\n", checksum(b))), 0644); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeInstallManifest records the absolute paths of the files install wrote
+// at manifest, one per line, so uninstall can remove them later.
+func writeInstallManifest(manifest string, paths []string) error {
+	var b strings.Builder
+
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(&b, abs)
+	}
+
+	return writeFileAtomic(manifest, []byte(b.String()), 0644)
+}
+
+func uninstallCommand(args []string) *cmderr {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	manifest := flags.String("manifest", "", "remove the files listed in the install manifest at `path`")
+
+	_, cerr := parseFlags(flags, args)
+	if cerr != nil {
+		return cerr
+	}
+
+	if *manifest == "" {
+		return &cmderr{1, "missing --manifest written by install --install-manifest"}
+	}
+
+	b, err := os.ReadFile(*manifest)
+
+	if err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		// Files removed by hand since are already uninstalled.
+		if err := os.Remove(line); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &cmderr{1, err.Error()}
+		}
+	}
+
+	if err := os.Remove(*manifest); err != nil {
+		return &cmderr{1, err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is the version of bin itself, set when building a release with
+// -ldflags "-X main.version=1.2.3". It is empty for development builds.
+var version = ""
+
+// semver is a parsed semantic version. Build metadata is ignored as it does
+// not take part in precedence.
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+
+	rest, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), "+")
+	core, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(core, ".")
+
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%s is not a semantic version", s)
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%s is not a semantic version", s)
+		}
+
+		v.core[i] = n
+	}
+
+	if hasPre {
+		v.prerelease = strings.Split(pre, ".")
+	}
+
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 if v has lower, equal or higher precedence
+// than w.
+func (v semver) compare(w semver) int {
+	for i := range v.core {
+		if c := cmp.Compare(v.core[i], w.core[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A pre-release has lower precedence than the release itself.
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		a, aerr := strconv.Atoi(v.prerelease[i])
+		b, berr := strconv.Atoi(w.prerelease[i])
+
+		var c int
+		switch {
+		case aerr == nil && berr == nil:
+			c = cmp.Compare(a, b)
+		case aerr == nil:
+			c = -1
+		case berr == nil:
+			c = 1
+		default:
+			c = strings.Compare(v.prerelease[i], w.prerelease[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return cmp.Compare(len(v.prerelease), len(w.prerelease))
+}
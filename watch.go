@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// follow calls check every interval until interrupted and prints a line with
+// the time and the result of check on the first call and whenever the result
+// changes.
+func follow(interval time.Duration, check func() *cmderr) *cmderr {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		result := green("OK")
+		if err := check(); err != nil {
+			result = fmt.Sprintf("%s (%s)", red("FAILED"), err.reason)
+		}
+
+		if result != last {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), result)
+			last = result
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
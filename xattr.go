@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// xattrRecord prefixes the PAX records holding extended attributes, the same
+// convention GNU tar and bsdtar use.
+const xattrRecord = "SCHILY.xattr."
+
+// xattrRecords returns attrs as PAX records.
+func xattrRecords(attrs map[string]string) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	records := map[string]string{}
+	for name, value := range attrs {
+		records[xattrRecord+name] = value
+	}
+	return records
+}
+
+// recordXattrs returns the extended attributes stored in PAX records.
+func recordXattrs(records map[string]string) map[string]string {
+	attrs := map[string]string{}
+	for key, value := range records {
+		if name, ok := strings.CutPrefix(key, xattrRecord); ok {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// warnXattrs warns that --preserve-xattrs has no effect on platforms without
+// extended attribute support.
+func warnXattrs() {
+	if !xattrsSupported {
+		fmt.Fprintf(os.Stderr, "warning: extended attributes are not supported on %s, --preserve-xattrs is ignored\n", runtime.GOOS)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const xattrsSupported = true
+
+// The syscall package has no wrappers for the xattr calls on darwin, whose
+// signatures also differ from Linux by a position and an options argument.
+
+func listxattr(path string, buf []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var b unsafe.Pointer
+	if len(buf) > 0 {
+		b = unsafe.Pointer(&buf[0])
+	}
+
+	n, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)), uintptr(b), uintptr(len(buf)), 0, 0, 0)
+
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(n), nil
+}
+
+func getxattr(path, name string, buf []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	a, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var b unsafe.Pointer
+	if len(buf) > 0 {
+		b = unsafe.Pointer(&buf[0])
+	}
+
+	n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(b), uintptr(len(buf)), 0, 0)
+
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(n), nil
+}
+
+func setxattr(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+
+	if err != nil {
+		return err
+	}
+
+	a, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return err
+	}
+
+	var b unsafe.Pointer
+	if len(value) > 0 {
+		b = unsafe.Pointer(&value[0])
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(b), uintptr(len(value)), 0, 0)
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// getXattrs returns the extended attributes of the file at path.
+func getXattrs(path string) (map[string]string, error) {
+	size, err := listxattr(path, nil)
+
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = listxattr(path, buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{}
+
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		size, err := getxattr(path, name, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		size, err = getxattr(path, name, value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attrs[name] = string(value[:size])
+	}
+
+	return attrs, nil
+}
+
+// setXattrs sets the extended attributes of the file at path.
+func setXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		if err := setxattr(path, name, []byte(value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+const xattrsSupported = true
+
+// getXattrs returns the extended attributes of the file at path.
+func getXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{}
+
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		size, err := syscall.Getxattr(path, name, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(path, name, value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attrs[name] = string(value[:size])
+	}
+
+	return attrs, nil
+}
+
+// setXattrs sets the extended attributes of the file at path.
+func setXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+const xattrsSupported = false
+
+func getXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func setXattrs(path string, attrs map[string]string) error {
+	return nil
+}